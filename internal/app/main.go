@@ -26,6 +26,9 @@ func Start(cfgPath string) error {
 	}
 	// refresh repos
 	repoService.Refresh()
+	repoService.StartLeaseReclaimer()
+	// clone and sync pre-warmed repo pools after repos are restored
+	repoService.StartPools()
 	// launch server
 	log.Println("Start repomaster server...")
 	return server.ListenAndServe()
@@ -13,18 +13,50 @@ func getWebHandler() *gin.Engine {
 	v1 := api.Group("/v1")
 	{
 		v1.GET("/health", handler.HealthCheck)
+		v1.GET("/events", handler.Event.Stream)
 		repos := v1.Group("/repos")
 		{
+			repos.DELETE("", handler.Repo.DeleteBatch)
 			repos.GET("/:id", handler.Repo.GetByID)
+			repos.DELETE("/:id", handler.Repo.Delete)
 
 			repos.POST("/:id/file", handler.Repo.GetFileInfo)
+			repos.GET("/:id/raw/*path", handler.Repo.GetRaw)
+			repos.POST("/:id/diff", handler.Repo.Diff)
+			repos.POST("/:id/diff/data", handler.Repo.DiffData)
+			repos.GET("/:id/log", handler.Repo.Log)
+			repos.GET("/:id/blame/*path", handler.Repo.Blame)
+			repos.POST("/:id/refs", handler.Repo.ListGitRefs)
+			repos.POST("/:id/resolve", handler.Repo.Resolve)
+			repos.GET("/:id/jobs", handler.Repo.ListJobs)
+		}
+		jobs := v1.Group("/jobs")
+		{
+			jobs.GET("/:id", handler.Job.GetByID)
+			jobs.POST("/:id/cancel", handler.Job.Cancel)
+		}
+		v1.GET("/pools", handler.Pool.List)
+		leases := v1.Group("/leases")
+		{
+			leases.GET("", handler.Lease.List)
+			leases.POST("", handler.Lease.Acquire)
+			leases.GET("/:id", handler.Lease.GetByID)
+			leases.POST("/:id/heartbeat", handler.Lease.Heartbeat)
+			leases.DELETE("/:id", handler.Lease.Release)
 		}
 		repo := v1.Group("/repo")
 		{
 			repo.GET("/snapshot", handler.Repo.GetSnapshot)
+			repo.GET("/deleted", handler.Repo.GetDeleted)
+			repo.POST("/diff", handler.Repo.DiffTrees)
+			repo.POST("/table", handler.Repo.GetTable)
+			repo.POST("/diff/table", handler.Repo.DiffTable)
 			repo.POST("/hash", handler.Repo.GetByHash)
 			repo.POST("/git", handler.Repo.CreateGit)
 			repo.PUT("/git", handler.Repo.UpdateGit)
+			repo.POST("/git/ls-remote", handler.Repo.LsRemoteGit)
+			repo.POST("/svn", handler.Repo.CreateSvn)
+			repo.PUT("/svn", handler.Repo.UpdateSvn)
 		}
 	}
 	return r
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	repoService "github.com/utmhikari/repomaster/internal/service/repo"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// eventKeepAliveInterval interval of keep-alive comments on idle event stream
+const eventKeepAliveInterval = 15 * time.Second
+
+type event struct{}
+
+// Event is the event handler instance
+var Event event
+
+// Stream stream events of repos and jobs as server-sent events, filtered by query repoId if specified
+func (_ *event) Stream(c *gin.Context) {
+	var repoID uint64 = 0
+	if repoIDStr := c.Query("repoId"); repoIDStr != "" {
+		id, err := strconv.ParseUint(repoIDStr, 10, 64)
+		if err != nil {
+			ErrorResponse(c, err)
+			return
+		}
+		repoID = id
+	}
+	events, unsubscribe := repoService.Subscribe(repoID)
+	defer unsubscribe()
+	ticker := time.NewTicker(eventKeepAliveInterval)
+	defer ticker.Stop()
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// flush headers at once so that clients know the stream is established
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(string(e.Type), e)
+			return true
+		case <-ticker.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
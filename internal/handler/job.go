@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	repoService "github.com/utmhikari/repomaster/internal/service/repo"
+	"net/http"
+	"strconv"
+)
+
+type job struct{}
+
+// Job is the job handler instance
+var Job job
+
+// GetByID get job info by ID
+func (_ *job) GetByID(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	j := repoService.GetJob(id)
+	if j == nil {
+		RequestError(c, http.StatusNotFound, Response{Message: fmt.Sprintf("cannot get job of id %d", id)})
+		return
+	}
+	SuccessDataResponse(c, *j)
+}
+
+// Cancel cancel a pending or running job
+func (_ *job) Cancel(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	if err := repoService.CancelJob(id); err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessMsgResponse(c, fmt.Sprintf("canceled job %d", id))
+}
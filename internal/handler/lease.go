@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/utmhikari/repomaster/internal/models"
+	repoService "github.com/utmhikari/repomaster/internal/service/repo"
+	"io"
+	"net/http"
+)
+
+type lease struct{}
+
+// Lease is the lease handler instance
+var Lease lease
+
+// List list active leases
+func (_ *lease) List(c *gin.Context) {
+	SuccessDataResponse(c, repoService.ListLeases())
+}
+
+// GetByID get lease info by ID
+func (_ *lease) GetByID(c *gin.Context) {
+	id := c.Param("id")
+	l := repoService.GetLease(id)
+	if l == nil {
+		RequestError(c, http.StatusNotFound, Response{Message: fmt.Sprintf("cannot get lease of id %s", id)})
+		return
+	}
+	SuccessDataResponse(c, *l)
+}
+
+// Acquire lease an idle repo at hash or ref, which is checked out or cloned if required
+func (_ *lease) Acquire(c *gin.Context) {
+	var request models.LeaseAcquireRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	if !repoService.IsValidType(request.Type) {
+		ErrorMsgResponse(c, request.Type+" is not a valid repo type")
+		return
+	}
+	if request.Hash == "" && request.Ref == "" {
+		ErrorMsgResponse(c, "either hash or ref is required")
+		return
+	}
+	opts := repoService.LeaseOptions{
+		Type:     repoService.Type(request.Type),
+		URL:      request.URL,
+		Hash:     request.Hash,
+		Ref:      request.Ref,
+		Holder:   request.Holder,
+		TTL:      request.TTL,
+		Clean:    request.Clean,
+		Labels:   request.Labels,
+		Priority: request.Priority,
+	}
+	switch opts.Type {
+	case repoService.TypeGit:
+		revision := models.GitRevision{Hash: request.Hash}
+		if request.Hash == "" {
+			revision = models.NewGitRevisionFromRef(request.Ref)
+		}
+		opts.Revision = revision
+		opts.Auth = request.GitAuth.ToAuthMethod()
+	case repoService.TypeSvn:
+		revision := models.SvnRevision{Revision: request.Hash}
+		if request.Hash == "" {
+			revision = models.NewSvnRevisionFromRef(request.Ref)
+		}
+		opts.Revision = revision
+		opts.Auth = &request.SvnAuth
+	default:
+		ErrorMsgResponse(c, "unsupported repo type to lease")
+		return
+	}
+	l, err := repoService.AcquireLease(opts)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, *l)
+}
+
+// Heartbeat renew lease to keep holding the repo
+func (_ *lease) Heartbeat(c *gin.Context) {
+	var request models.LeaseHeartbeatRequest
+	// request body is optional
+	if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		ErrorResponse(c, err)
+		return
+	}
+	l, err := repoService.RenewLease(c.Param("id"), request.TTL)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, *l)
+}
+
+// Release release lease so that the repo could be leased by others
+func (_ *lease) Release(c *gin.Context) {
+	id := c.Param("id")
+	if err := repoService.ReleaseLease(id); err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessMsgResponse(c, fmt.Sprintf("released lease %s", id))
+}
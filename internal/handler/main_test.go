@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"github.com/utmhikari/repomaster/pkg/util"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain run tests with a global config whose repo root is a temp dir
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "repomaster-handler-test-")
+	if err != nil {
+		log.Fatalf("cannot create temp dir! %s\n", err.Error())
+	}
+	cfgPath := filepath.Join(dir, "repomaster.json")
+	// fixtures are local repos
+	c := cfg.Config{Port: 18080, RepoRoot: filepath.Join(dir, "repos"), AllowLocalURL: true}
+	if err := util.WriteJsonFile(cfgPath, &c); err != nil {
+		log.Fatalf("cannot write config! %s\n", err.Error())
+	}
+	if err := cfg.InitGlobalConfig(cfgPath); err != nil {
+		log.Fatalf("cannot init config! %s\n", err.Error())
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTempDir create a temp dir removed after test
+func newTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "repomaster-handler-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// testResponse the json response of handlers
+type testResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+	Code    int             `json:"code"`
+}
+
+// postJSON post request as json to handler, returns the decoded response
+func postJSON(t *testing.T, handle gin.HandlerFunc, request interface{}) testResponse {
+	t.Helper()
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.POST("/", handle)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	var resp testResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("cannot decode response %s! %s", w.Body.String(), err.Error())
+	}
+	return resp
+}
@@ -0,0 +1,16 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	repoService "github.com/utmhikari/repomaster/internal/service/repo"
+)
+
+type pool struct{}
+
+// Pool is the pre-warmed repo pool handler instance
+var Pool pool
+
+// List list status of pre-warmed repo pools in config
+func (_ *pool) List(c *gin.Context) {
+	SuccessDataResponse(c, repoService.ListPools())
+}
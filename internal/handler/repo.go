@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
 	repoService "github.com/utmhikari/repomaster/internal/service/repo"
+	"github.com/utmhikari/repomaster/pkg/table"
+	"io"
 	"log"
+	"net/http"
 	"strconv"
+	"time"
 )
 
 type repo struct{}
@@ -14,6 +19,40 @@ type repo struct{}
 // Repo is the repo handler instance
 var Repo repo
 
+// defaultLogLimit default page size of commit log
+const defaultLogLimit = 20
+
+// maxLogLimit max page size of commit log
+const maxLogLimit = 500
+
+// repoErrorResponse responds typed errors of repo service with http status code,
+// other errors are logged and responded with defaultMsg
+func repoErrorResponse(c *gin.Context, err error, defaultMsg string) {
+	code := repoService.GetErrorCode(err)
+	statusCode := http.StatusOK
+	switch code {
+	case repoService.ErrorCodeRepoNotFound, repoService.ErrorCodePathNotFound, repoService.ErrorCodeRevisionNotFound,
+		repoService.ErrorCodeJobNotFound, repoService.ErrorCodeLeaseNotFound:
+		statusCode = http.StatusNotFound
+	case repoService.ErrorCodePathForbidden, repoService.ErrorCodeURLForbidden:
+		statusCode = http.StatusForbidden
+	case repoService.ErrorCodeRepoUpdating, repoService.ErrorCodeJobFinished, repoService.ErrorCodeRepoLeased:
+		statusCode = http.StatusConflict
+	case repoService.ErrorCodeIsDirectory:
+		statusCode = http.StatusBadRequest
+	case repoService.ErrorCodeFileTooLarge:
+		statusCode = http.StatusRequestEntityTooLarge
+	case repoService.ErrorCodeQueueFull, repoService.ErrorCodeNoIdleRepo:
+		statusCode = http.StatusTooManyRequests
+		c.Header("Retry-After", strconv.Itoa(cfg.Global().RetryAfter))
+	default:
+		log.Printf(err.Error())
+		ErrorMsgResponse(c, defaultMsg)
+		return
+	}
+	RequestError(c, statusCode, Response{Message: err.Error(), Code: int(code)})
+}
+
 // GetByID get repo info by ID
 func (_ *repo) GetByID(c *gin.Context) {
 	idStr := c.Param("id")
@@ -41,8 +80,16 @@ func (_ *repo) GetByHash(c *gin.Context) {
 		ErrorMsgResponse(c, request.Type+" is not a valid repo type")
 		return
 	}
-	_, r := repoService.FindRepoByHash(
-		repoService.Type(request.Type), request.URL, request.Hash)
+	if request.Hash == "" && request.Ref == "" {
+		ErrorMsgResponse(c, "either hash or ref is required")
+		return
+	}
+	var r *repoService.Repo
+	if request.Hash != "" {
+		_, r = repoService.FindRepoByHash(repoService.Type(request.Type), request.URL, request.Hash)
+	} else {
+		_, r = repoService.FindRepoByRef(repoService.Type(request.Type), request.URL, request.Ref)
+	}
 	if r != nil {
 		SuccessDataResponse(c, *r)
 		return
@@ -52,20 +99,42 @@ func (_ *repo) GetByHash(c *gin.Context) {
 		return
 	}
 	// try create repo if not exist
-	// TODO: support svn
-	if request.Type != string(repoService.TypeGit) {
+	var repoID uint64 = 0
+	var job *repoService.Job
+	var err error
+	switch repoService.Type(request.Type) {
+	case repoService.TypeGit:
+		gitRepoCreateOptions := models.GitRepoCreateOptions{URL: request.URL, Auth: request.GitAuth}
+		gitCloneOptions := gitRepoCreateOptions.ToCloneOptions()
+		if gitCloneOptions == nil {
+			ErrorMsgResponse(c, "failed to get git clone options")
+			return
+		}
+		revision := models.GitRevision{Hash: request.Hash}
+		if request.Hash == "" {
+			revision = models.NewGitRevisionFromRef(request.Ref)
+		}
+		repoID, job, err = repoService.CreateGitRepo(gitCloneOptions, revision, request.Labels, request.Priority, true)
+	case repoService.TypeSvn:
+		svnRepoCreateOptions := models.SvnRepoCreateOptions{URL: request.URL, Auth: request.SvnAuth}
+		revision := models.SvnRevision{Revision: request.Hash}
+		if request.Hash == "" {
+			revision = models.NewSvnRevisionFromRef(request.Ref)
+		}
+		repoID, job, err = repoService.CreateSvnRepo(&svnRepoCreateOptions, revision, request.Labels, request.Priority, true)
+	default:
 		ErrorMsgResponse(c, "unsupported repo type to create")
 		return
 	}
-	gitRepoCreateOptions := models.GitRepoCreateOptions{URL: request.URL, Auth: request.GitAuth}
-	gitCloneOptions := gitRepoCreateOptions.ToCloneOptions()
-	if gitCloneOptions == nil {
-		ErrorMsgResponse(c, "failed to get git clone options")
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
 		return
 	}
-	revision := models.GitRevision{Hash: request.Hash}
-	repoID := repoService.CreateGitRepo(gitCloneOptions, revision, true)
 	if repoID == 0 {
+		if job != nil && job.Error != "" {
+			ErrorMsgResponse(c, "create repo failed! "+job.Error)
+			return
+		}
 		ErrorMsgResponse(c, "create repo failed")
 		return
 	}
@@ -99,8 +168,7 @@ func (_ *repo) GetFileInfo(c *gin.Context) {
 	}
 	stat, err := repoService.GetFileInfoOfRepo(id, request.Path)
 	if err != nil {
-		log.Printf(err.Error())
-		ErrorMsgResponse(c, "cannot get file stat")
+		repoErrorResponse(c, err, "cannot get file stat")
 		return
 	}
 	if !stat.IsDir {
@@ -113,8 +181,7 @@ func (_ *repo) GetFileInfo(c *gin.Context) {
 	}
 	fileInfoList, err := repoService.GetFileInfoListOfRepo(id, request.Path)
 	if err != nil {
-		log.Printf(err.Error())
-		ErrorMsgResponse(c, "cannot get filelist of dir")
+		repoErrorResponse(c, err, "cannot get filelist of dir")
 		return
 	}
 	SuccessDataResponse(c, &models.RepoGetFileInfoResponse{
@@ -124,6 +191,267 @@ func (_ *repo) GetFileInfo(c *gin.Context) {
 	})
 }
 
+// GetRaw get raw content of specific file in repo
+func (_ *repo) GetRaw(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	f, err := repoService.OpenRawFileOfRepo(id, c.Param("path"))
+	if err != nil {
+		repoErrorResponse(c, err, "cannot read file")
+		return
+	}
+	defer f.Close()
+	// content type, range and conditional requests are handled by ServeContent
+	c.Header("ETag", fmt.Sprintf("\"%s\"", f.Hash))
+	http.ServeContent(c.Writer, c.Request, f.Stat.Name(), f.Stat.ModTime(), f)
+}
+
+// Diff list changed files and patches between two revisions of specific repo
+func (_ *repo) Diff(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	var request models.RepoDiffRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	from, err := repoService.ParseRevisionOfRepo(id, request.From)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	to, err := repoService.ParseRevisionOfRepo(id, request.To)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	changes, err := repoService.DiffRepo(id, from, to, repoService.DiffOptions{
+		Path:  request.Path,
+		Patch: request.Patch,
+	})
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, changes)
+}
+
+// DiffData semantic diff of json/yaml files at two revisions of a repo or in two repos
+func (_ *repo) DiffData(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	var request models.RepoDataDiffRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	to := models.RepoFileRef{ID: request.ToID, Revision: request.To, Path: request.ToPath}
+	if to.ID == 0 {
+		to.ID = id
+	}
+	changes, err := repoService.DiffRepoData(
+		models.RepoFileRef{ID: id, Revision: request.From, Path: request.Path}, to, request.Options)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, changes)
+}
+
+// parseTimeQuery parse time of query param in RFC3339 or date format, zero if empty
+func parseTimeQuery(c *gin.Context, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s of %s", value, key)
+	}
+	return t, nil
+}
+
+// parseIntQuery parse int of query param, defaultValue if empty
+func parseIntQuery(c *gin.Context, key string, defaultValue int) (int, error) {
+	value := c.Query(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid %s %s", key, value)
+	}
+	return i, nil
+}
+
+// Resolve resolve revision spec or expression to the commit of repo
+func (_ *repo) Resolve(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	var request models.RepoResolveRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	revision, err := repoService.ParseRevisionOfRepo(id, request.Revision)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	commit, err := repoService.ResolveRevisionOfRepo(id, revision)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, commit)
+}
+
+// Log get paginated commit history of repo,
+// revisions of from and to are json of GitRevision or SvnRevision in query params
+func (_ *repo) Log(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	options := repoService.LogOptions{
+		Path:   c.Query("path"),
+		Author: c.Query("author"),
+	}
+	if options.From, err = repoService.ParseRevisionOfRepo(id, []byte(c.Query("from"))); err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	if options.To, err = repoService.ParseRevisionOfRepo(id, []byte(c.Query("to"))); err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	if options.Since, err = parseTimeQuery(c, "since"); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	if options.Until, err = parseTimeQuery(c, "until"); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	if options.Skip, err = parseIntQuery(c, "offset", 0); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	if options.Limit, err = parseIntQuery(c, "limit", defaultLogLimit); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	if options.Limit == 0 || options.Limit > maxLogLimit {
+		options.Limit = maxLogLimit
+	}
+	page, err := repoService.LogRepo(id, options)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, page)
+}
+
+// Blame get the last commit changed each line of file,
+// revision is json of GitRevision or SvnRevision in query param, head if empty
+func (_ *repo) Blame(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	revision, err := repoService.ParseRevisionOfRepo(id, []byte(c.Query("revision")))
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	lines, err := repoService.BlameRepo(id, revision, c.Param("path"))
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, lines)
+}
+
+// DiffTrees compare working trees of two repos
+func (_ *repo) DiffTrees(c *gin.Context) {
+	var request models.RepoTreeDiffRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	changes, err := repoService.DiffRepoTrees(request.FromID, request.ToID, repoService.DiffOptions{
+		Path:  request.Path,
+		Patch: request.Patch,
+	})
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, changes)
+}
+
+// GetTable get sheets of csv/tsv table or xlsx workbook with typed cell values
+func (_ *repo) GetTable(c *gin.Context) {
+	var request models.RepoTableGetRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	w, err := repoService.ReadRepoWorkbook(request.RepoFileRef, request.Options)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, w)
+}
+
+// DiffTable structured diff of csv/tsv tables or xlsx workbooks at two revisions or in two repos
+func (_ *repo) DiffTable(c *gin.Context) {
+	var request models.RepoTableDiffRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	if table.IsXlsxFile(request.From.Path) {
+		d, err := repoService.DiffRepoWorkbooks(request.From, request.To, request.Options, request.Diff)
+		if err != nil {
+			repoErrorResponse(c, err, err.Error())
+			return
+		}
+		SuccessDataResponse(c, d)
+		return
+	}
+	d, err := repoService.DiffRepoTables(request.From, request.To, request.Options, request.Diff)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, d)
+}
+
 // GetSnapshot get snapshot of the cache
 func (_ *repo) GetSnapshot(c *gin.Context) {
 	snapshot := repoService.GetCacheSnapshot()
@@ -147,8 +475,12 @@ func (_ *repo) CreateGit(c *gin.Context) {
 		ErrorMsgResponse(c, "cannot get clone options for git repo")
 		return
 	}
-	repoID := repoService.CreateGitRepo(gitOptions, request.Revision, false)
-	SuccessMsgResponse(c, fmt.Sprintf("launched git clone at repo %d", repoID))
+	repoID, job, err := repoService.CreateGitRepo(gitOptions, request.Revision, request.Labels, request.Priority, false)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	Success(c, Response{Message: fmt.Sprintf("launched git clone at repo %d", repoID), Data: job})
 }
 
 // UpdateGit update an existed git repo
@@ -158,11 +490,133 @@ func (_ *repo) UpdateGit(c *gin.Context) {
 		ErrorResponse(c, err)
 		return
 	}
-	checkUpdateErr := repoService.UpdateGitRepo(
-		request.ID, request.Revision, request.Auth.ToAuthMethod(), false)
+	job, checkUpdateErr := repoService.UpdateGitRepo(
+		request.ID, request.Revision, request.Auth.ToAuthMethod(), request.Priority, false)
 	if checkUpdateErr != nil {
-		ErrorResponse(c, checkUpdateErr)
+		repoErrorResponse(c, checkUpdateErr, checkUpdateErr.Error())
 		return
 	}
-	SuccessMsgResponse(c, "launched checkout")
+	Success(c, Response{Message: "launched checkout", Data: job})
+}
+
+// ListGitRefs list local and remote branches and tags of a git repo, optionally fetch first
+func (_ *repo) ListGitRefs(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	var request models.GitRefListRequest
+	// request body is optional
+	if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		ErrorResponse(c, err)
+		return
+	}
+	refs, err := repoService.ListGitRefs(id, request.Fetch, request.Auth.ToAuthMethod())
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, refs)
+}
+
+// LsRemoteGit list branches and tags of a git url without cloning
+func (_ *repo) LsRemoteGit(c *gin.Context) {
+	var request models.GitLsRemoteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	refs, err := repoService.LsRemoteGit(request.URL, request.Auth.ToAuthMethod())
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessDataResponse(c, refs)
+}
+
+// CreateSvn create a new svn repo
+func (_ *repo) CreateSvn(c *gin.Context) {
+	var request models.SvnRepoCreateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	if request.Type != string(repoService.TypeSvn) {
+		ErrorMsgResponse(c, fmt.Sprintf("invalid repo type %s", request.Type))
+		return
+	}
+	repoID, job, err := repoService.CreateSvnRepo(&request.Options, request.Revision, request.Labels, request.Priority, false)
+	if err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	Success(c, Response{Message: fmt.Sprintf("launched svn checkout at repo %d", repoID), Data: job})
+}
+
+// UpdateSvn update an existed svn repo
+func (_ *repo) UpdateSvn(c *gin.Context) {
+	var request models.SvnRepoUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	job, checkUpdateErr := repoService.UpdateSvnRepo(
+		request.ID, request.Revision, &request.Auth, request.Priority, false)
+	if checkUpdateErr != nil {
+		repoErrorResponse(c, checkUpdateErr, checkUpdateErr.Error())
+		return
+	}
+	Success(c, Response{Message: "launched update", Data: job})
+}
+
+// ListJobs list clone and checkout jobs of repo, newest first
+func (_ *repo) ListJobs(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	SuccessDataResponse(c, repoService.ListJobsOfRepo(id))
+}
+
+// Delete delete an existed repo and its working copy
+func (_ *repo) Delete(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+	if err := repoService.DeleteRepo(id); err != nil {
+		repoErrorResponse(c, err, err.Error())
+		return
+	}
+	SuccessMsgResponse(c, fmt.Sprintf("deleted repo %d", id))
+}
+
+// DeleteBatch delete repos filtered by url or status
+func (_ *repo) DeleteBatch(c *gin.Context) {
+	url := c.Query("url")
+	status := c.Query("status")
+	if url == "" && status == "" {
+		ErrorMsgResponse(c, "url or status is required to filter repos")
+		return
+	}
+	if status != "" && !repoService.IsValidStatus(status) {
+		ErrorMsgResponse(c, status+" is not a valid repo status")
+		return
+	}
+	deleted, failed := repoService.DeleteRepos(url, repoService.Status(status))
+	SuccessDataResponse(c, &models.RepoDeleteBatchResponse{
+		Deleted: deleted,
+		Failed:  failed,
+	})
+}
+
+// GetDeleted get records of deleted repos
+func (_ *repo) GetDeleted(c *gin.Context) {
+	SuccessDataResponse(c, repoService.GetDeletedRepos())
 }
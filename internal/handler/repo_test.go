@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/utmhikari/repomaster/internal/models"
+	repoService "github.com/utmhikari/repomaster/internal/service/repo"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newGitUpstream create a local upstream git repo with one commit, returns its root and the commit hash
+func newGitUpstream(t *testing.T) (string, string) {
+	t.Helper()
+	root := filepath.Join(newTempDir(t), "upstream")
+	r, err := git.PlainInit(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	signature := &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()}
+	h, err := w.Commit("init", &git.CommitOptions{Author: signature})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root, h.String()
+}
+
+func TestGetByHashReusesRepo(t *testing.T) {
+	url, hash := newGitUpstream(t)
+	for _, request := range []models.RepoGetByHashRequest{
+		{Type: "git", URL: url, Hash: hash, CreateIfNotExist: true},
+		{Type: "git", URL: url, Hash: hash, CreateIfNotExist: true},
+		{Type: "git", URL: url + "/", Hash: hash[:7], CreateIfNotExist: true},
+		{Type: "git", URL: url + ".git", Hash: hash[:10], CreateIfNotExist: true},
+	} {
+		resp := postJSON(t, Repo.GetByHash, request)
+		if !resp.Success {
+			t.Fatalf("cannot get repo by %+v! %s", request, resp.Message)
+		}
+		var r repoService.Repo
+		if err := json.Unmarshal(resp.Data, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Commit.Hash != hash {
+			t.Fatalf("expect repo at %s, got %s", hash, r.Commit.Hash)
+		}
+	}
+	items := repoService.FindReposByURL(repoService.TypeGit, url)
+	defer func() {
+		for _, item := range items {
+			_ = repoService.DeleteRepo(item.ID)
+		}
+	}()
+	if len(items) != 1 {
+		t.Fatalf("expect only one repo cloned, got %+v", items)
+	}
+	if jobs := repoService.ListJobsOfRepo(items[0].ID); len(jobs) != 1 || jobs[0].Type != repoService.JobTypeClone {
+		t.Fatalf("expect only the clone job of repo %d, got %+v", items[0].ID, jobs)
+	}
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/utmhikari/repomaster/pkg/datadiff"
+	"github.com/utmhikari/repomaster/pkg/table"
+)
+
+// FileChangeAction action of a changed file
+type FileChangeAction string
+
+const (
+	FileChangeAdd    FileChangeAction = "add"
+	FileChangeModify FileChangeAction = "modify"
+	FileChangeDelete FileChangeAction = "delete"
+	FileChangeRename FileChangeAction = "rename"
+)
+
+// FileChange change of a file between two revisions
+type FileChange struct {
+	Action  FileChangeAction `json:"action"`
+	Path    string           `json:"path"`
+	OldPath string           `json:"oldPath"`
+	Patch   string           `json:"patch,omitempty"`
+}
+
+// RepoDiffRequest request for diff two revisions of a repo,
+// revisions are GitRevision or SvnRevision by the type of repo, head if empty
+type RepoDiffRequest struct {
+	From  json.RawMessage `json:"from"`
+	To    json.RawMessage `json:"to"`
+	Path  string          `json:"path"`
+	Patch bool            `json:"patch"`
+}
+
+// TreeFileChange change of a file between working trees of two repos
+type TreeFileChange struct {
+	FileChange
+	OldSize int64  `json:"oldSize"`
+	Size    int64  `json:"size"`
+	OldHash string `json:"oldHash"`
+	Hash    string `json:"hash"`
+}
+
+// RepoTreeDiffRequest request for diff working trees of two repos
+type RepoTreeDiffRequest struct {
+	FromID uint64 `json:"fromId" binding:"required"`
+	ToID   uint64 `json:"toId" binding:"required"`
+	Path   string `json:"path"`
+	Patch  bool   `json:"patch"`
+}
+
+// RepoFileRef reference of a file in repo, at working tree if revision is empty
+type RepoFileRef struct {
+	ID       uint64          `json:"id"`
+	Revision json.RawMessage `json:"revision"`
+	Path     string          `json:"path"`
+}
+
+// RepoTableGetRequest request for reading csv/tsv table or xlsx workbook in repo
+type RepoTableGetRequest struct {
+	RepoFileRef
+	Options table.Options `json:"options"`
+}
+
+// RepoTableDiffRequest request for structured diff of csv/tsv tables or xlsx workbooks
+// at two revisions or in two repos, path of to is the same as from if empty
+type RepoTableDiffRequest struct {
+	From    RepoFileRef       `json:"from" binding:"required"`
+	To      RepoFileRef       `json:"to" binding:"required"`
+	Options table.Options     `json:"options"`
+	Diff    table.DiffOptions `json:"diff"`
+}
+
+// RepoDataDiffRequest request for semantic diff of json/yaml files,
+// to is compared in repo of toId if specified, at path of toPath if specified
+type RepoDataDiffRequest struct {
+	From    json.RawMessage  `json:"from"`
+	To      json.RawMessage  `json:"to"`
+	Path    string           `json:"path" binding:"required"`
+	ToID    uint64           `json:"toId"`
+	ToPath  string           `json:"toPath"`
+	Options datadiff.Options `json:"options"`
+}
@@ -7,13 +7,28 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"log"
 	"os"
+	"strings"
 )
 
-// GitRevision specification of a git version
+// GitRevision specification of a git version, priority: hash > rev > tag > branch
 type GitRevision struct {
 	Branch string `json:"branch"`
 	Tag    string `json:"tag"`
-	Hash   string `json:"hash"`
+	// Hash is the full or abbreviated commit hash
+	Hash string `json:"hash"`
+	// Rev is a revision expression, e.g. HEAD~3, refs/remotes/origin/main or main@{2020-01-01}
+	Rev string `json:"rev"`
+}
+
+// NewGitRevisionFromRef get revision to checkout ref, e.g. refs/heads/main, refs/tags/v1 or main
+func NewGitRevisionFromRef(ref string) GitRevision {
+	if strings.HasPrefix(ref, "refs/tags/") {
+		return GitRevision{Tag: strings.TrimPrefix(ref, "refs/tags/")}
+	}
+	if strings.HasPrefix(ref, "refs/") && !strings.HasPrefix(ref, "refs/heads/") {
+		return GitRevision{Rev: ref}
+	}
+	return GitRevision{Branch: strings.TrimPrefix(ref, "refs/heads/")}
 }
 
 // GitAuth auth cfg of git
@@ -69,6 +84,9 @@ type GitRepoCreateRequest struct {
 	Type     string               `json:"type" binding:"required"`
 	Options  GitRepoCreateOptions `json:"options" binding:"required"`
 	Revision GitRevision          `json:"revision"`
+	Labels   map[string]string    `json:"labels"`
+	// Priority is the priority in job queue, higher ones run first
+	Priority int `json:"priority"`
 }
 
 // GitRepoUpdateRequest request for update version of an existed git repo
@@ -76,4 +94,18 @@ type GitRepoUpdateRequest struct {
 	ID       uint64      `json:"id" binding:"required"`
 	Revision GitRevision `json:"revision"`
 	Auth     GitAuth     `json:"auth"`
+	// Priority is the priority in job queue, higher ones run first
+	Priority int `json:"priority"`
+}
+
+// GitRefListRequest request for listing branches and tags of a git repo
+type GitRefListRequest struct {
+	Fetch bool    `json:"fetch"`
+	Auth  GitAuth `json:"auth"`
+}
+
+// GitLsRemoteRequest request for listing branches and tags of a git url without cloning
+type GitLsRemoteRequest struct {
+	URL  string  `json:"url" binding:"required"`
+	Auth GitAuth `json:"auth"`
 }
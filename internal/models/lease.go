@@ -0,0 +1,28 @@
+package models
+
+// LeaseAcquireRequest request for leasing a repo at hash, or at ref if hash is empty
+type LeaseAcquireRequest struct {
+	Type string `json:"type" binding:"required"`
+	URL  string `json:"url" binding:"required"`
+	Hash string `json:"hash"`
+	// Ref is the ref checked out, e.g. refs/heads/main for git and ^/trunk for svn
+	Ref string `json:"ref"`
+	// Holder is the description of lease holder, e.g. the name of ci job
+	Holder string `json:"holder"`
+	// TTL is the seconds to expire without heartbeat, the default one if not positive
+	TTL int64 `json:"ttl"`
+	// Clean cleans up the repo before leased even if it's at hash or ref already
+	Clean   bool    `json:"clean"`
+	GitAuth GitAuth `json:"gitAuth"`
+	SvnAuth SvnAuth `json:"svnAuth"`
+	// Labels labels of repo if cloned
+	Labels map[string]string `json:"labels"`
+	// Priority priority in job queue if checked out or cloned
+	Priority int `json:"priority"`
+}
+
+// LeaseHeartbeatRequest request for renewing a lease
+type LeaseHeartbeatRequest struct {
+	// TTL is the seconds to expire since now, the ttl of lease is kept if not positive
+	TTL int64 `json:"ttl"`
+}
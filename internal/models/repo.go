@@ -1,13 +1,21 @@
 package models
 
-// RepoGetByHashRequest request for get repo instance by hash
+import "encoding/json"
+
+// RepoGetByHashRequest request for get repo instance by hash, or by ref if hash is empty
 type RepoGetByHashRequest struct {
-	Type             string  `json:"type" binding:"required"`
-	URL              string  `json:"url" binding:"required"`
-	Hash             string  `json:"hash" binding:"required"`
+	Type string `json:"type" binding:"required"`
+	URL  string `json:"url" binding:"required"`
+	Hash string `json:"hash"`
+	// Ref is the ref checked out, e.g. refs/heads/main for git and ^/trunk for svn
+	Ref              string  `json:"ref"`
 	CreateIfNotExist bool    `json:"createIfNotExist"`
 	GitAuth          GitAuth `json:"gitAuth"`
-	// TODO: support svn
+	SvnAuth          SvnAuth `json:"svnAuth"`
+	// Labels labels of repo if created
+	Labels map[string]string `json:"labels"`
+	// Priority priority in job queue if created
+	Priority int `json:"priority"`
 }
 
 // RepoGetFileInfoRequest request for get file info from repo
@@ -21,3 +29,15 @@ type RepoGetFileInfoResponse struct {
 	FileInfo     *FileInfo   `json:"fileInfo"`
 	FileInfoList *[]FileInfo `json:"fileInfoList"`
 }
+
+// RepoDeleteBatchResponse response for delete repos in batch
+type RepoDeleteBatchResponse struct {
+	Deleted []uint64          `json:"deleted"`
+	Failed  map[uint64]string `json:"failed"`
+}
+
+// RepoResolveRequest request for resolving revision of repo,
+// revision is GitRevision or SvnRevision by the type of repo, head if empty
+type RepoResolveRequest struct {
+	Revision json.RawMessage `json:"revision"`
+}
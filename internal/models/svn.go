@@ -0,0 +1,73 @@
+package models
+
+import (
+	"io"
+	"strings"
+)
+
+// SvnRevision specification of a svn version
+type SvnRevision struct {
+	// Path is the path relative to the repository root to switch to,
+	// e.g. trunk or branches/release, keeps the current one if empty
+	Path string `json:"path"`
+	// Revision is the revision number, HEAD if empty
+	Revision string `json:"revision"`
+}
+
+// NewSvnRevisionFromRef get revision to checkout ref at HEAD, e.g. ^/trunk or branches/release
+func NewSvnRevisionFromRef(ref string) SvnRevision {
+	return SvnRevision{Path: strings.TrimPrefix(ref, "^/")}
+}
+
+// SvnAuth auth cfg of svn
+type SvnAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ToArgs convert SvnAuth to svn command line args,
+// the password is never in args as they are visible to other processes, feed Stdin to the command instead
+func (a *SvnAuth) ToArgs() []string {
+	args := []string{"--non-interactive"}
+	if a == nil || a.Username == "" {
+		return args
+	}
+	args = append(args, "--no-auth-cache", "--username", a.Username)
+	if a.Password != "" {
+		args = append(args, "--password-from-stdin")
+	}
+	return args
+}
+
+// Stdin get the stdin of svn command to read password from, nil if no password
+func (a *SvnAuth) Stdin() io.Reader {
+	if a == nil || a.Username == "" || a.Password == "" {
+		return nil
+	}
+	return strings.NewReader(a.Password + "\n")
+}
+
+// SvnRepoCreateOptions options for creating a svn repo
+type SvnRepoCreateOptions struct {
+	URL  string  `json:"url" binding:"required"`
+	Auth SvnAuth `json:"auth"`
+}
+
+// SvnRepoCreateRequest request for create a new svn repo
+type SvnRepoCreateRequest struct {
+	Type     string               `json:"type" binding:"required"`
+	Options  SvnRepoCreateOptions `json:"options" binding:"required"`
+	Revision SvnRevision          `json:"revision"`
+	Labels   map[string]string    `json:"labels"`
+	// Priority is the priority in job queue, higher ones run first
+	Priority int `json:"priority"`
+}
+
+// SvnRepoUpdateRequest request for update version of an existed svn repo
+type SvnRepoUpdateRequest struct {
+	ID       uint64      `json:"id" binding:"required"`
+	Revision SvnRevision `json:"revision"`
+	Auth     SvnAuth     `json:"auth"`
+	// Priority is the priority in job queue, higher ones run first
+	Priority int `json:"priority"`
+}
@@ -6,12 +6,161 @@ import (
 	"github.com/utmhikari/repomaster/pkg/util"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+// DefaultMaxRawFileSize default max size of file to read raw content, 32MB
+const DefaultMaxRawFileSize int64 = 32 << 20
+
+// DefaultCloneTimeout default timeout of cloning a repo in seconds
+const DefaultCloneTimeout = 3600
+
+// DefaultPullTimeout default timeout of pulling a repo in seconds
+const DefaultPullTimeout = 1800
+
+// DefaultCheckoutTimeout default timeout of checking out a repo in seconds, including clean and pull
+const DefaultCheckoutTimeout = 3600
+
+// DefaultMaxWorkers default max count of concurrent clone and checkout jobs
+const DefaultMaxWorkers = 4
+
+// DefaultMaxQueueSize default max count of queued jobs
+const DefaultMaxQueueSize = 100
+
+// DefaultMaxJobsPerURL default max count of concurrent jobs of the same url
+const DefaultMaxJobsPerURL = 2
+
+// DefaultRetryAfter default seconds for clients to retry after when the job queue is full or no repo could be leased
+const DefaultRetryAfter = 30
+
+// DefaultMaxReposPerURL default max count of repos of the same url that leases could clone
+const DefaultMaxReposPerURL = 8
+
+// DefaultLeaseTTL default ttl of repo leases in seconds
+const DefaultLeaseTTL = 600
+
+// DefaultMaxLeaseTTL default max ttl of repo leases in seconds
+const DefaultMaxLeaseTTL = 86400
+
+// DefaultPoolSyncInterval default interval of syncing pre-warmed repo pools in seconds
+const DefaultPoolSyncInterval = 300
+
+// PoolRefConfig the count of repos to keep at a ref in pool
+type PoolRefConfig struct {
+	// Ref is the branch name or full ref for git, e.g. main or refs/tags/v1.0, and path for svn, e.g. ^/trunk
+	Ref   string `json:"ref"`
+	Count int    `json:"count"`
+}
+
+// PoolConfig the pool of pre-warmed repos of url, which are cloned at startup and kept at the refs in background
+type PoolConfig struct {
+	// Name is the unique name of pool labeled on its repos, url by default
+	Name string `json:"name"`
+	// Type is the repo type, git by default
+	Type     string `json:"type"`
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Key is the ssh private key for git
+	Key      string          `json:"key"`
+	Refs     []PoolRefConfig `json:"refs"`
+	Priority int             `json:"priority"`
+}
+
+// String format pool config without credentials, as config is logged at startup
+func (p PoolConfig) String() string {
+	return fmt.Sprintf("{Name:%s Type:%s URL:%s Username:%s Refs:%+v Priority:%d}",
+		p.Name, p.Type, p.URL, p.Username, p.Refs, p.Priority)
+}
+
+// check validity of pool config
+func (p *PoolConfig) check() error {
+	if p.URL == "" {
+		return errors.New("url of pool is required")
+	}
+	if p.Name == "" {
+		p.Name = p.URL
+	}
+	if p.Type == "" {
+		p.Type = "git"
+	}
+	if p.Type != "git" && p.Type != "svn" {
+		return errors.New(fmt.Sprintf("invalid repo type %s of pool %s", p.Type, p.Name))
+	}
+	refs := make(map[string]bool)
+	for _, r := range p.Refs {
+		if r.Ref == "" || r.Count < 0 {
+			return errors.New(fmt.Sprintf("invalid ref %s with count %d of pool %s", r.Ref, r.Count, p.Name))
+		}
+		if refs[r.Ref] {
+			return errors.New(fmt.Sprintf("duplicated ref %s of pool %s", r.Ref, p.Name))
+		}
+		refs[r.Ref] = true
+	}
+	return nil
+}
+
 // Config is the app cfg template
 type Config struct {
-	Port     int    `json:"port"`
-	RepoRoot string `json:"repoRoot"`
+	Port           int    `json:"port"`
+	RepoRoot       string `json:"repoRoot"`
+	MaxRawFileSize int64  `json:"maxRawFileSize"`
+	ExposeVcsDir   bool   `json:"exposeVcsDir"`
+	// timeouts in seconds, negative for no timeout
+	CloneTimeout    int `json:"cloneTimeout"`
+	PullTimeout     int `json:"pullTimeout"`
+	CheckoutTimeout int `json:"checkoutTimeout"`
+	// limits of job worker pool
+	MaxWorkers    int `json:"maxWorkers"`
+	MaxQueueSize  int `json:"maxQueueSize"`
+	MaxJobsPerURL int `json:"maxJobsPerUrl"`
+	// RetryAfter is the seconds for clients to retry after when the job queue is full or no repo could be leased
+	RetryAfter int `json:"retryAfter"`
+	// limits of repo leases, ttl in seconds
+	MaxReposPerURL int `json:"maxReposPerUrl"`
+	LeaseTTL       int `json:"leaseTtl"`
+	MaxLeaseTTL    int `json:"maxLeaseTtl"`
+	// DisableGitMirror disables cloning git repos from the bare mirror of url shared by alternates
+	DisableGitMirror bool `json:"disableGitMirror"`
+	// AllowLocalURL allows repos of local paths and file urls requested by api, which expose files of server
+	AllowLocalURL bool `json:"allowLocalUrl"`
+	// pre-warmed repo pools, synced in interval seconds
+	Pools            []PoolConfig `json:"pools"`
+	PoolSyncInterval int          `json:"poolSyncInterval"`
+}
+
+// toTimeout convert timeout seconds to duration, 0 if no timeout
+func toTimeout(seconds int) time.Duration {
+	if seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// GetCloneTimeout get timeout of cloning a repo, 0 if no timeout
+func (c *Config) GetCloneTimeout() time.Duration {
+	return toTimeout(c.CloneTimeout)
+}
+
+// GetPullTimeout get timeout of pulling a repo, 0 if no timeout
+func (c *Config) GetPullTimeout() time.Duration {
+	return toTimeout(c.PullTimeout)
+}
+
+// GetCheckoutTimeout get timeout of checking out a repo, 0 if no timeout
+func (c *Config) GetCheckoutTimeout() time.Duration {
+	return toTimeout(c.CheckoutTimeout)
+}
+
+// GetLeaseTTL get ttl of repo lease by requested seconds, default ttl if not positive, capped by max ttl
+func (c *Config) GetLeaseTTL(seconds int64) time.Duration {
+	if seconds <= 0 {
+		seconds = int64(c.LeaseTTL)
+	}
+	if seconds > int64(c.MaxLeaseTTL) {
+		seconds = int64(c.MaxLeaseTTL)
+	}
+	return time.Duration(seconds) * time.Second
 }
 
 // check validity of config instance
@@ -20,6 +169,65 @@ func (c *Config) Check() error {
 	if c.Port < 3000 {
 		return errors.New(fmt.Sprintf("invalid port number: %d", c.Port))
 	}
+	// check max raw file size
+	if c.MaxRawFileSize <= 0 {
+		c.MaxRawFileSize = DefaultMaxRawFileSize
+	}
+	// check timeouts
+	if c.CloneTimeout == 0 {
+		c.CloneTimeout = DefaultCloneTimeout
+	}
+	if c.PullTimeout == 0 {
+		c.PullTimeout = DefaultPullTimeout
+	}
+	if c.CheckoutTimeout == 0 {
+		c.CheckoutTimeout = DefaultCheckoutTimeout
+	}
+	// check worker pool
+	if c.MaxWorkers <= 0 {
+		c.MaxWorkers = DefaultMaxWorkers
+	}
+	if c.MaxQueueSize <= 0 {
+		c.MaxQueueSize = DefaultMaxQueueSize
+	}
+	if c.MaxJobsPerURL <= 0 {
+		c.MaxJobsPerURL = DefaultMaxJobsPerURL
+	}
+	if c.RetryAfter <= 0 {
+		c.RetryAfter = DefaultRetryAfter
+	}
+	// check leases
+	if c.MaxReposPerURL <= 0 {
+		c.MaxReposPerURL = DefaultMaxReposPerURL
+	}
+	if c.MaxLeaseTTL <= 0 {
+		c.MaxLeaseTTL = DefaultMaxLeaseTTL
+	}
+	if c.LeaseTTL <= 0 {
+		c.LeaseTTL = DefaultLeaseTTL
+	}
+	if c.LeaseTTL > c.MaxLeaseTTL {
+		c.LeaseTTL = c.MaxLeaseTTL
+	}
+	// check pools
+	if c.PoolSyncInterval <= 0 {
+		c.PoolSyncInterval = DefaultPoolSyncInterval
+	}
+	poolNames := make(map[string]bool)
+	for i := range c.Pools {
+		if err := c.Pools[i].check(); err != nil {
+			return err
+		}
+		// pools are cloned like repos requested by api
+		if !c.AllowLocalURL && util.IsLocalURL(c.Pools[i].URL) {
+			return errors.New(fmt.Sprintf("local url %s of pool %s is forbidden unless allowLocalUrl is set",
+				c.Pools[i].URL, c.Pools[i].Name))
+		}
+		if poolNames[c.Pools[i].Name] {
+			return errors.New(fmt.Sprintf("duplicated pool name %s", c.Pools[i].Name))
+		}
+		poolNames[c.Pools[i].Name] = true
+	}
 	// check repo root
 	absRepoRoot, absRepoRootErr := filepath.Abs(c.RepoRoot)
 	if absRepoRootErr != nil {
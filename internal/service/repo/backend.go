@@ -0,0 +1,229 @@
+package repo
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/utmhikari/repomaster/internal/models"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Revision is the revision spec handled by a backend,
+// e.g. models.GitRevision for git and models.SvnRevision for svn
+type Revision interface{}
+
+// Auth is the auth method handled by a backend,
+// e.g. transport.AuthMethod for git and *models.SvnAuth for svn
+type Auth interface{}
+
+// Head the head info of a working copy
+type Head struct {
+	// URL is the remote url of the working copy
+	URL string
+	// Commit is the head commit of the working copy
+	Commit Commit
+}
+
+// DiffOptions options for diffing two revisions
+type DiffOptions struct {
+	// Path limits the diff to files under path, all files if empty
+	Path string
+	// Patch is whether to generate unified patches of files
+	Patch bool
+}
+
+// LogOptions options for listing commits of a working copy
+type LogOptions struct {
+	// To is the newest revision to list commits from, head if nil
+	To Revision
+	// From excludes commits reachable from it like from..to, nothing excluded if nil
+	From Revision
+	// Path limits to commits touching files under path, all commits if empty
+	Path string
+	// Author limits to commits whose author name or email contains it, case insensitive
+	Author string
+	// Since limits to commits not earlier than it, unlimited if zero
+	Since time.Time
+	// Until limits to commits not later than it, unlimited if zero
+	Until time.Time
+	// Skip is the count of matched commits to skip
+	Skip int
+	// Limit is the max count of commits, no limit if not positive
+	Limit int
+}
+
+// matchCommit does commit match author and time filters of options
+func (o *LogOptions) matchCommit(c Commit) bool {
+	if o.Author != "" {
+		author := strings.ToLower(o.Author)
+		if !strings.Contains(strings.ToLower(c.Author), author) &&
+			!strings.Contains(strings.ToLower(c.Email), author) {
+			return false
+		}
+	}
+	if !o.Since.IsZero() && c.Time.Before(o.Since) {
+		return false
+	}
+	if !o.Until.IsZero() && c.Time.After(o.Until) {
+		return false
+	}
+	return true
+}
+
+// hasTimeOrAuthorFilter are commits filtered by author or time
+func (o *LogOptions) hasTimeOrAuthorFilter() bool {
+	return o.Author != "" || !o.Since.IsZero() || !o.Until.IsZero()
+}
+
+// logCollector collects commits matching log options by skip and limit
+type logCollector struct {
+	options *LogOptions
+	skipped int
+	commits []Commit
+}
+
+// add adds commit if matched, returns false if limit is reached
+func (l *logCollector) add(c Commit) bool {
+	if l.options.Limit > 0 && len(l.commits) >= l.options.Limit {
+		return false
+	}
+	if !l.options.matchCommit(c) {
+		return true
+	}
+	if l.skipped < l.options.Skip {
+		l.skipped++
+		return true
+	}
+	l.commits = append(l.commits, c)
+	return l.options.Limit <= 0 || len(l.commits) < l.options.Limit
+}
+
+// BlameLine a line of file with the last commit changed it
+type BlameLine struct {
+	Line    int       `json:"line"`
+	Text    string    `json:"text"`
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Time    time.Time `json:"time"`
+	Summary string    `json:"summary"`
+}
+
+// getSummary get the first line of commit message
+func getSummary(message string) string {
+	message = strings.TrimSpace(message)
+	if i := strings.IndexByte(message, '\n'); i >= 0 {
+		return strings.TrimSpace(message[:i])
+	}
+	return message
+}
+
+// Backend is the vcs implementation of a repo type
+type Backend interface {
+	// Type is the repo type served by the backend
+	Type() Type
+	// ParseRevision decodes json revision spec of the backend, nil if empty
+	ParseRevision(data []byte) (Revision, error)
+	// Clone creates a working copy of url at root, at specific revision, reporting to progress
+	Clone(ctx stdcontext.Context, root string, url string, revision Revision, auth Auth, progress Progress) error
+	// Resolve resolves revision to the commit of the working copy at root, head if nil
+	Resolve(root string, revision Revision) (*Commit, error)
+	// Open checks whether root is a working copy of the backend
+	Open(root string) error
+	// Head gets the head info of the working copy at root
+	Head(root string) (*Head, error)
+	// Checkout fetches and moves the working copy at root to specific revision, reporting to progress
+	Checkout(ctx stdcontext.Context, root string, revision Revision, auth Auth, progress Progress) error
+	// Clean resets the working copy at root to a pristine state of url
+	Clean(root string, url string) error
+	// Diff lists changed files between two revisions
+	Diff(root string, from Revision, to Revision, options DiffOptions) ([]models.FileChange, error)
+	// Log lists commits of the working copy at root
+	Log(root string, options LogOptions) ([]Commit, error)
+	// Cat opens content of file at revision, returns its size or -1 if unknown
+	Cat(root string, revision Revision, filePath string) (io.ReadCloser, int64, error)
+	// Blame gets the last commit changed each line of file at revision
+	Blame(root string, revision Revision, filePath string) ([]BlameLine, error)
+}
+
+// backends the registered backends by repo type
+var backends = make(map[Type]Backend)
+
+// backendsMu mutex to protect backends
+var backendsMu sync.RWMutex
+
+// RegisterBackend registers backend for its repo type, replaces the existed one
+func RegisterBackend(b Backend) {
+	backendsMu.Lock()
+	backends[b.Type()] = b
+	backendsMu.Unlock()
+}
+
+// getBackend get backend of repo type
+func getBackend(t Type) Backend {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	return backends[t]
+}
+
+// detectBackend find the backend which could open the working copy at root
+func detectBackend(root string) Backend {
+	backendsMu.RLock()
+	var types []string
+	for t := range backends {
+		types = append(types, string(t))
+	}
+	backendsMu.RUnlock()
+	sort.Strings(types)
+	for _, t := range types {
+		b := getBackend(Type(t))
+		if b != nil && b.Open(root) == nil {
+			return b
+		}
+	}
+	return nil
+}
+
+// withTimeout derive context with timeout if positive, otherwise cancelable only
+func withTimeout(ctx stdcontext.Context, timeout time.Duration) (stdcontext.Context, stdcontext.CancelFunc) {
+	if timeout > 0 {
+		return stdcontext.WithTimeout(ctx, timeout)
+	}
+	return stdcontext.WithCancel(ctx)
+}
+
+// errUnexpectedRevision error of revision spec not handled by backend
+func errUnexpectedRevision(t Type, revision Revision) error {
+	return errors.New(fmt.Sprintf("unexpected revision %+v for %s repo", revision, t))
+}
+
+// errUnexpectedAuth error of auth method not handled by backend
+func errUnexpectedAuth(t Type, auth Auth) error {
+	return errors.New(fmt.Sprintf("unexpected auth %T for %s repo", auth, t))
+}
+
+// isPathUnder is slash separated file path under dir path, empty dir path contains all
+func isPathUnder(filePath string, dirPath string) bool {
+	dirPath = strings.Trim(path.Clean("/"+dirPath), "/")
+	if dirPath == "" {
+		return true
+	}
+	return filePath == dirPath || strings.HasPrefix(filePath, dirPath+"/")
+}
+
+// parseJsonRevision decode json data to revision spec, nil if empty
+func parseJsonRevision(data []byte, revision Revision) (Revision, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, revision); err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
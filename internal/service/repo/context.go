@@ -1,6 +1,13 @@
 package repo
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
 
 // Type repo type
 type Type string
@@ -11,9 +18,9 @@ const (
 	TypeSvn     Type = "svn"
 )
 
-// IsValidType is type value valid
+// IsValidType is type value valid, which has a registered backend
 func IsValidType(t string) bool {
-	return t == string(TypeGit) || t == string(TypeSvn)
+	return getBackend(Type(t)) != nil
 }
 
 // Status repo status
@@ -30,16 +37,18 @@ const (
 func IsValidStatus(s string) bool {
 	return s == string(StatusActive) ||
 		s == string(StatusError) ||
-		s == string(StatusUpdating)
+		s == string(StatusUpdating) ||
+		s == string(StatusUnknown)
 }
 
 // Commit repo head commit info
 type Commit struct {
-	Hash    string `json:"hash"`
-	Ref     string `json:"ref"`
-	Message string `json:"message"`
-	Author  string `json:"author"`
-	Email   string `json:"email"`
+	Hash    string    `json:"hash"`
+	Ref     string    `json:"ref"`
+	Message string    `json:"message"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Time    time.Time `json:"time"`
 }
 
 // Repo the info of a spefific repo
@@ -58,6 +67,27 @@ type Repo struct {
 
 	// Commit is the current commmit info of repo
 	Commit Commit `json:"commit"`
+
+	// Revision is the revision requested at last create or update
+	Revision Revision `json:"revision"`
+
+	// Labels is the custom labels of repo
+	Labels map[string]string `json:"labels"`
+
+	// Auth is the info of auth used at last create or update
+	Auth AuthInfo `json:"auth"`
+
+	// QueuePosition is the 1-based position of the first queued job of repo, 0 if not queued
+	QueuePosition int `json:"queuePosition,omitempty"`
+
+	// Leased is whether repo is leased, leased repos couldn't be updated or deleted by others
+	Leased bool `json:"leased,omitempty"`
+
+	// CreatedAt is the time when repo is created or discovered
+	CreatedAt time.Time `json:"createdAt"`
+
+	// UpdatedAt is the time when repo info is updated
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 // IsActive is in active status
@@ -74,10 +104,13 @@ func (r *Repo) IsStatusNormal() bool {
 func (r *Repo) SetStatusError(errMsg string) {
 	r.Status = StatusError
 	r.Desc = errMsg
+	r.UpdatedAt = time.Now()
 }
 
 // context the repo context in repomaster runtime
 type context struct {
+	// id the unique id of repo
+	id uint64
 	// root the local root of repo
 	root string
 	// mu mutex to protect repo instance
@@ -89,6 +122,7 @@ type context struct {
 // SetRepoStatus set status of repo instance with lock
 func (c *context) SetRepoStatus(status Status) {
 	c.mu.Lock()
+	oldStatus := c.v.Status
 	switch status {
 	case StatusError:
 		c.v.Status = StatusError
@@ -103,14 +137,20 @@ func (c *context) SetRepoStatus(status Status) {
 		c.v.Status = StatusUnknown
 		break
 	}
+	c.v.UpdatedAt = time.Now()
+	repoCopy := c.v
 	c.mu.Unlock()
+	publishStatusChange(c.id, oldStatus, &repoCopy)
 }
 
 // SetRepoStatusError set status of repo as error with lock
 func (c *context) SetRepoStatusError(errMsg string) {
 	c.mu.Lock()
+	oldStatus := c.v.Status
 	c.v.SetStatusError(errMsg)
+	repoCopy := c.v
 	c.mu.Unlock()
+	publishStatusChange(c.id, oldStatus, &repoCopy)
 }
 
 // SetRepoType set type of repo with lock
@@ -130,9 +170,222 @@ func (c *context) SetRepoType(repoType Type) {
 	c.mu.Unlock()
 }
 
-// IsRepoStatusNormal is repo at normal status
-func (c *context) IsRepoStatusNormal() bool {
+// tryAcquire atomically transit repo to updating status if its current status is accepted,
+// returns the previous status and whether acquired.
+// Only the holder of updating status could mutate the working copy, and it must release the status
+// by refreshRepo, SetRepoStatus or SetRepoStatusError, e.g. active -> updating -> active/error
+func (c *context) tryAcquire(accept func(status Status) bool) (Status, bool) {
+	c.mu.Lock()
+	prevStatus := c.v.Status
+	if prevStatus == StatusUpdating || !accept(prevStatus) {
+		c.mu.Unlock()
+		return prevStatus, false
+	}
+	c.v.Status = StatusUpdating
+	c.v.UpdatedAt = time.Now()
+	repoCopy := c.v
+	c.mu.Unlock()
+	publishStatusChange(c.id, prevStatus, &repoCopy)
+	return prevStatus, true
+}
+
+// acceptAnyStatus accept any status which is not updating
+func acceptAnyStatus(status Status) bool {
+	return true
+}
+
+// acceptActiveStatus accept active status only
+func acceptActiveStatus(status Status) bool {
+	return status == StatusActive
+}
+
+// acceptSettledStatus accept active or error status, so that a failed mutation could be retried
+func acceptSettledStatus(status Status) bool {
+	return status == StatusActive || status == StatusError
+}
+
+// getBackend get backend of the repo type
+func (c *context) getBackend() (Backend, error) {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.v.IsStatusNormal()
+	t := c.v.Type
+	c.mu.RUnlock()
+	b := getBackend(t)
+	if b == nil {
+		return nil, errors.New(fmt.Sprintf("no backend for repo type %s", t))
+	}
+	return b, nil
+}
+
+// refreshRepo refresh context by head info of backend
+func (c *context) refreshRepo(b Backend) bool {
+	head, err := b.Head(c.root)
+	if err != nil {
+		log.Printf("cannot refresh %s as %s repo! %s\n", c.root, b.Type(), err.Error())
+		c.SetRepoStatusError(err.Error())
+		return false
+	}
+	// refresh data
+	c.mu.Lock()
+	oldStatus, oldCommit, oldURL, oldType := c.v.Status, c.v.Commit, c.v.URL, c.v.Type
+	c.v.URL = head.URL
+	c.v.Commit = head.Commit
+	c.v.Type = b.Type()
+	c.v.Status = StatusActive
+	c.v.UpdatedAt = time.Now()
+	log.Printf("refreshed %s as %s repo: %+v\n", c.root, b.Type(), c.v)
+	repoCopy := c.v
+	c.mu.Unlock()
+	index.put(c.id, repoCopy)
+	// status is not persisted on every transition as repos are refreshed at startup anyway
+	if oldCommit != repoCopy.Commit || oldURL != repoCopy.URL || oldType != repoCopy.Type {
+		saveStore()
+	}
+	if oldCommit.Hash != repoCopy.Commit.Hash || oldCommit.Ref != repoCopy.Commit.Ref {
+		publish(EventRepoCommit, c.id, RepoCommitEventData{Old: oldCommit, New: repoCopy.Commit})
+	}
+	publishStatusChange(c.id, oldStatus, &repoCopy)
+	return true
+}
+
+// setRepoRequest record the revision and auth requested for repo with lock
+func (c *context) setRepoRequest(revision Revision, auth Auth) {
+	c.mu.Lock()
+	c.v.Revision = revision
+	c.v.Auth = newAuthInfo(auth)
+	c.v.UpdatedAt = time.Now()
+	c.mu.Unlock()
+	saveStore()
+}
+
+// finishJob finish job of repo with the head commit or error
+func (c *context) finishJob(job *jobContext, err error) {
+	if err != nil {
+		job.finish(nil, err)
+		return
+	}
+	c.mu.RLock()
+	head := c.v.Commit
+	c.mu.RUnlock()
+	job.finish(&head, nil)
+}
+
+// checkoutRepo checkout repo to specific revision on behalf of lease, tracked by job,
+// the repo must not be leased if lease id is empty, or be leased by it otherwise
+func (c *context) checkoutRepo(revision Revision, auth Auth, isNeededCleanUp bool, leaseID string,
+	job *jobContext) (err error) {
+	jobCtx := job.start()
+	defer func() {
+		c.finishJob(job, err)
+	}()
+	// the job may be canceled while pending
+	if err := jobCtx.Err(); err != nil {
+		return err
+	}
+	// acquire updating status, the status must be released on every path below
+	if curStatus, err := leases.tryAcquireRepo(c, leaseID, acceptSettledStatus); err == ErrRepoLeased {
+		log.Printf("failed to checkout repo at %s! repo is leased\n", c.root)
+		return err
+	} else if err != nil {
+		log.Printf("failed to checkout repo at %s! current status is %s\n",
+			c.root, string(curStatus))
+		return errors.New(fmt.Sprintf("cannot checkout repo in %s status", curStatus))
+	}
+	b, err := c.getBackend()
+	if err != nil {
+		log.Printf("failed to checkout repo at %s! %s\n", c.root, err.Error())
+		c.SetRepoStatusError(err.Error())
+		return err
+	}
+	c.setRepoRequest(revision, auth)
+	log.Printf("checkout repo at %s to revision %+v...\n", c.root, revision)
+	if err := b.Open(c.root); err != nil {
+		log.Printf("failed to checkout repo at %s! cannot open repo! %s\n",
+			c.root, err.Error())
+		c.SetRepoStatusError(err.Error())
+		return err
+	}
+	// the head is refreshed on every path below, and the repo is kept in error status if failed
+	defer func() {
+		job.SetPhase(JobPhaseRefresh)
+		if c.refreshRepo(b) && err != nil {
+			c.SetRepoStatusError(err.Error())
+		}
+	}()
+	// check if cleanup is needed
+	if isNeededCleanUp {
+		log.Printf("cleaning up repo at %s...\n", c.root)
+		job.SetPhase(JobPhaseClean)
+		c.mu.RLock()
+		url := c.v.URL
+		c.mu.RUnlock()
+		if err := b.Clean(c.root, url); err != nil {
+			log.Printf("failed to clean up repo at %s! %s\n", c.root, err.Error())
+			return err
+		}
+	}
+	if err := b.Checkout(jobCtx, c.root, revision, auth, job); err != nil {
+		log.Printf("failed to checkout repo at %s to revision %+v! %s\n",
+			c.root, revision, err.Error())
+		return err
+	}
+	log.Printf("successfully checkout repo at %s to revision %+v...\n",
+		c.root, revision)
+	// refresh info
+	return nil
+}
+
+// removeHalfCloned remove the working copy and context of repo failed to clone
+func (c *context) removeHalfCloned() {
+	if c.root != getRepoRoot(c.id) {
+		log.Printf("refuse to remove unexpected repo root %s\n", c.root)
+		return
+	}
+	if err := os.RemoveAll(c.root); err != nil {
+		log.Printf("failed to remove half-cloned repo %d at %s! %s\n", c.id, c.root, err.Error())
+		return
+	}
+	c.mu.RLock()
+	t, url := c.v.Type, c.v.URL
+	c.mu.RUnlock()
+	deleteContext(c.id)
+	saveStore()
+	log.Printf("removed half-cloned repo %d at %s\n", c.id, c.root)
+	// the mirror is created by the first clone of url
+	removeGitMirrorOfURLIfUnused(t, url)
+}
+
+// createRepo create working copy of url at revision, tracked by job
+func (c *context) createRepo(b Backend, url string, revision Revision, auth Auth, job *jobContext) (err error) {
+	jobCtx := job.start()
+	defer func() {
+		c.finishJob(job, err)
+	}()
+	// the job may be canceled while pending
+	if err := jobCtx.Err(); err != nil {
+		c.removeHalfCloned()
+		return err
+	}
+	// before clone
+	c.mu.Lock()
+	c.v.URL = url
+	repoCopy := c.v
+	c.mu.Unlock()
+	index.put(c.id, repoCopy)
+	c.setRepoRequest(revision, auth)
+	// clone
+	if err := b.Clone(jobCtx, c.root, url, revision, auth, job); err != nil {
+		log.Printf("failed to clone %s repo to %s --- %s", b.Type(), c.root, err.Error())
+		c.SetRepoStatusError(err.Error())
+		c.removeHalfCloned()
+		return err
+	}
+	// refresh info
+	job.SetPhase(JobPhaseRefresh)
+	if !c.refreshRepo(b) {
+		c.mu.RLock()
+		desc := c.v.Desc
+		c.mu.RUnlock()
+		return errors.New(fmt.Sprintf("cannot refresh repo after clone! %s", desc))
+	}
+	return nil
 }
@@ -0,0 +1,293 @@
+package repo
+
+import (
+	stdcontext "context"
+	"errors"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+	"github.com/utmhikari/repomaster/internal/models"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestContext create a context of repo at an unused id
+func newTestContext(t *testing.T, status Status) *context {
+	t.Helper()
+	ctx, id := requestNewContextWithID(TypeGit, status)
+	if ctx == nil {
+		t.Fatal("cannot request new context")
+	}
+	t.Cleanup(func() {
+		deleteContext(id)
+	})
+	return ctx
+}
+
+// assertSettled assert status of repo is active or error rather than updating
+func assertSettled(t *testing.T, id uint64) {
+	t.Helper()
+	r := GetRepo(id)
+	if r == nil {
+		return
+	}
+	if r.Status != StatusActive && r.Status != StatusError {
+		t.Fatalf("expect repo %d settled at active or error status, got %s", id, r.Status)
+	}
+}
+
+func TestTryAcquireRejectsConcurrentMutation(t *testing.T) {
+	ctx := newTestContext(t, StatusActive)
+	for round := 0; round < 100; round++ {
+		var acquired int32
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if prevStatus, ok := ctx.tryAcquire(acceptActiveStatus); ok {
+					atomic.AddInt32(&acquired, 1)
+				} else if prevStatus != StatusUpdating {
+					t.Errorf("expect rejected in updating status, got %s", prevStatus)
+				}
+			}()
+		}
+		wg.Wait()
+		if acquired != 1 {
+			t.Fatalf("expect exactly one mutation acquired in round %d, got %d", round, acquired)
+		}
+		// the second mutation is rejected until released
+		if _, ok := ctx.tryAcquire(acceptAnyStatus); ok {
+			t.Fatal("expect mutation rejected while updating")
+		}
+		if round%2 == 0 {
+			ctx.SetRepoStatus(StatusActive)
+		} else {
+			ctx.SetRepoStatusError("failed")
+			ctx.SetRepoStatus(StatusActive)
+		}
+		assertSettled(t, ctx.id)
+	}
+}
+
+func TestRefreshRepoReleasesStatus(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	ctx := newTestContext(t, StatusUnknown)
+	cloneGitFixture(t, f, ctx.root, models.GitRevision{})
+	t.Cleanup(func() {
+		_ = os.RemoveAll(ctx.root)
+	})
+	b := getBackend(TypeGit)
+
+	// refreshed to active
+	if _, ok := ctx.tryAcquire(acceptAnyStatus); !ok {
+		t.Fatal("cannot acquire repo")
+	}
+	if !ctx.refreshRepo(b) {
+		t.Fatal("cannot refresh repo")
+	}
+	assertSettled(t, ctx.id)
+	if r := GetRepo(ctx.id); r.Status != StatusActive || r.Commit.Hash != f.commits[2].String() {
+		t.Fatalf("unexpected repo after refresh: %+v", r)
+	}
+
+	// refreshed to error if the working copy is broken
+	if _, ok := ctx.tryAcquire(acceptAnyStatus); !ok {
+		t.Fatal("cannot acquire repo")
+	}
+	if ctx.refreshRepo(getBackend(TypeSvn)) {
+		t.Fatal("expect refresh as svn repo failed")
+	}
+	assertSettled(t, ctx.id)
+	if r := GetRepo(ctx.id); r.Status != StatusError {
+		t.Fatalf("expect error status after failed refresh, got %s", r.Status)
+	}
+}
+
+func TestConcurrentCheckoutOfOneRepo(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	ctx := newTestContext(t, StatusUnknown)
+	cloneGitFixture(t, f, ctx.root, models.GitRevision{})
+	t.Cleanup(func() {
+		_ = os.RemoveAll(ctx.root)
+	})
+	refreshContextByID(ctx.id)
+	assertSettled(t, ctx.id)
+
+	// checkouts bypassing worker pool race for the updating status
+	revisions := []models.GitRevision{{Tag: "same"}, {Tag: "release"}, {Branch: "same"}, {Branch: "master"}}
+	var succeeded, rejected int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(revision models.GitRevision) {
+			defer wg.Done()
+			job := createJob(ctx.id, JobTypeCheckout, revision, 0, 0)
+			err := ctx.checkoutRepo(revision, nil, true, "", job)
+			switch {
+			case err == nil:
+				atomic.AddInt32(&succeeded, 1)
+			case strings.Contains(err.Error(), "updating status"):
+				atomic.AddInt32(&rejected, 1)
+			default:
+				t.Errorf("unexpected checkout error: %s", err.Error())
+			}
+			if v := job.snapshot(); !v.Status.IsDone() {
+				t.Errorf("expect job %d done, got %s", v.ID, v.Status)
+			}
+		}(revisions[i%len(revisions)])
+	}
+	// refreshes race with checkouts, and skip the repo in updating status
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			refreshContextByID(ctx.id)
+		}()
+	}
+	wg.Wait()
+	if succeeded+rejected != 16 {
+		t.Fatalf("unexpected checkout results: %d succeeded, %d rejected", succeeded, rejected)
+	}
+	assertSettled(t, ctx.id)
+	// every racer has released the status, so the next checkout is not rejected
+	job := createJob(ctx.id, JobTypeCheckout, revisions[0], 0, 0)
+	if err := ctx.checkoutRepo(revisions[0], nil, true, "", job); err != nil {
+		t.Fatal(err)
+	}
+	assertGitHead(t, ctx.root, f.commits[0], plumbing.HEAD.String())
+}
+
+// cancelingTransport transport canceling job at the first upload pack session, i.e. while fetching
+type cancelingTransport struct {
+	transport.Transport
+	job *jobContext
+}
+
+func (c *cancelingTransport) NewUploadPackSession(ep *transport.Endpoint,
+	auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	if err := c.job.cancelJob(); err != nil {
+		return nil, err
+	}
+	return nil, stdcontext.Canceled
+}
+
+func TestCanceledCheckoutKeepsErrorStatus(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	id := createGitRepo(t, f)
+	ctx := getContext(id)
+	revision := models.GitRevision{Tag: "release"}
+	job := createJob(id, JobTypeCheckout, revision, 0, 0)
+	client.InstallProtocol("file", &cancelingTransport{Transport: file.DefaultClient, job: job})
+	err := ctx.checkoutRepo(revision, nil, true, "", job)
+	client.InstallProtocol("file", file.DefaultClient)
+	if err == nil {
+		t.Fatal("expect checkout canceled")
+	}
+	if v := job.snapshot(); v.Status != JobStatusCanceled {
+		t.Fatalf("expect job canceled, got %s", v.Status)
+	}
+	// the head is refreshed while the error is kept
+	r := GetRepo(id)
+	if r.Status != StatusError || r.Desc != err.Error() || r.Commit.Hash != f.commits[2].String() {
+		t.Fatalf("unexpected repo after canceled checkout: %+v", r)
+	}
+	// the failed checkout could be retried
+	job = createJob(id, JobTypeCheckout, revision, 0, 0)
+	if err := ctx.checkoutRepo(revision, nil, true, "", job); err != nil {
+		t.Fatal(err)
+	}
+	if r := GetRepo(id); r.Status != StatusActive {
+		t.Fatalf("expect active status after retried checkout, got %s", r.Status)
+	}
+	assertGitHead(t, ctx.root, f.commits[1], plumbing.HEAD.String())
+}
+
+func TestConcurrentCreateUpdateDelete(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	id, job, err := CreateRepo(TypeGit, f.root, models.GitRevision{}, nil, nil, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == 0 {
+		t.Fatalf("cannot create repo! %+v", job)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := []uint64{id}
+	// concurrent creates get different ids
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newID, _, err := CreateRepo(TypeGit, f.root, models.GitRevision{Tag: "release"}, nil, nil, 0, true)
+			if err != nil {
+				t.Errorf("cannot create repo! %s", err.Error())
+				return
+			}
+			mu.Lock()
+			created = append(created, newID)
+			mu.Unlock()
+		}()
+	}
+	// updates of the same repo are queued, and fail once the repo is deleted
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			revision := models.GitRevision{Tag: "same"}
+			if i%2 == 0 {
+				revision = models.GitRevision{Branch: "same"}
+			}
+			_, _ = UpdateRepo(id, TypeGit, revision, nil, 0, true)
+		}(i)
+	}
+	// deleting races with updates, and is rejected while the repo is updating
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			err := DeleteRepo(id)
+			if err == nil || err == ErrRepoNotFound {
+				return
+			}
+			if err != ErrRepoUpdating {
+				t.Errorf("unexpected delete error: %s", err.Error())
+				return
+			}
+		}
+	}()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			refreshContextByID(id)
+			_, _ = ListGitRefs(id, true, nil)
+		}()
+	}
+	wg.Wait()
+	if GetRepo(id) != nil {
+		t.Fatalf("expect repo %d deleted", id)
+	}
+	seen := make(map[uint64]bool)
+	for _, createdID := range created {
+		if seen[createdID] {
+			t.Fatalf("repo id %d is created twice", createdID)
+		}
+		seen[createdID] = true
+		assertSettled(t, createdID)
+		if createdID != id {
+			if err := DeleteRepo(createdID); err != nil && !errors.Is(err, ErrRepoNotFound) {
+				t.Fatal(err)
+			}
+		}
+	}
+}
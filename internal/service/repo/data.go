@@ -0,0 +1,41 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/pkg/datadiff"
+)
+
+// readDataByRef read and parse json/yaml file referenced in repo
+func readDataByRef(ref models.RepoFileRef) (interface{}, error) {
+	if !datadiff.IsDataFile(ref.Path) {
+		return nil, errors.New(fmt.Sprintf("%s is not a json/yaml file", ref.Path))
+	}
+	content, err := ReadFileByRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	v, err := datadiff.Parse(ref.Path, content)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse %s of repo %d! %s", ref.Path, ref.ID, err.Error()))
+	}
+	return v, nil
+}
+
+// DiffRepoData semantic diff of json/yaml files at two revisions or in two repos,
+// path of to is the same as from if empty
+func DiffRepoData(from models.RepoFileRef, to models.RepoFileRef, options datadiff.Options) ([]datadiff.Change, error) {
+	if to.Path == "" {
+		to.Path = from.Path
+	}
+	fromData, err := readDataByRef(from)
+	if err != nil {
+		return nil, err
+	}
+	toData, err := readDataByRef(to)
+	if err != nil {
+		return nil, err
+	}
+	return datadiff.Diff(fromData, toData, options), nil
+}
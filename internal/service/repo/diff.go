@@ -0,0 +1,222 @@
+package repo
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"github.com/utmhikari/repomaster/pkg/util"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// treeFile a file found by walking the working tree of repo
+type treeFile struct {
+	realPath string
+	info     *models.FileInfo
+}
+
+// isSymlink is the file a symlink
+func (f *treeFile) isSymlink() bool {
+	return os.FileMode(f.info.Mode)&os.ModeSymlink != 0
+}
+
+// read read content of file, or link target of symlink
+func (f *treeFile) read() ([]byte, error) {
+	if f.isSymlink() {
+		target, err := os.Readlink(f.realPath)
+		return []byte(target), err
+	}
+	return ioutil.ReadFile(f.realPath)
+}
+
+// hash compute git blob hash of file content
+func (f *treeFile) hash() (string, error) {
+	if f.isSymlink() {
+		content, err := f.read()
+		if err != nil {
+			return "", err
+		}
+		return plumbing.ComputeHash(plumbing.BlobObject, content).String(), nil
+	}
+	file, err := os.Open(f.realPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := plumbing.NewHasher(plumbing.BlobObject, f.info.Size)
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hasher.Sum().String(), nil
+}
+
+// toPatchFile convert to one side of file patch
+func (f *treeFile) toPatchFile(relPath string, hash string) (*patchFile, error) {
+	content, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	mode, err := filemode.NewFromOSFileMode(os.FileMode(f.info.Mode))
+	if err != nil {
+		mode = filemode.Regular
+	}
+	return &patchFile{
+		path:    relPath,
+		hash:    plumbing.NewHash(hash),
+		mode:    mode,
+		content: content,
+	}, nil
+}
+
+// walkRepoTree list files under dir of repo recursively, keyed by slash separated relative path
+func walkRepoTree(id uint64, dirPath string) (map[string]*treeFile, error) {
+	realDirPath, err := resolveRepoPath(id, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*treeFile)
+	var walk func(relPath string) error
+	walk = func(relPath string) error {
+		realPath := filepath.Join(realDirPath, filepath.FromSlash(relPath))
+		fileInfoList, err := util.ListFilesOfDirectory(realPath)
+		if err != nil {
+			return err
+		}
+		for _, fileInfo := range *fileInfoList {
+			if isHiddenName(fileInfo.Name()) {
+				continue
+			}
+			childRelPath := path.Join(relPath, fileInfo.Name())
+			if fileInfo.IsDir() {
+				if err := walk(childRelPath); err != nil {
+					return err
+				}
+				continue
+			}
+			files[childRelPath] = &treeFile{
+				realPath: filepath.Join(realPath, fileInfo.Name()),
+				info:     models.NewFileInfoFromStat(fileInfo),
+			}
+		}
+		return nil
+	}
+	if !util.IsDirectory(realDirPath) {
+		return nil, ErrPathNotFound
+	}
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ParseRevisionOfRepo decode json revision spec by the type of repo
+func ParseRevisionOfRepo(id uint64, data []byte) (Revision, error) {
+	ctx := getContext(id)
+	if ctx == nil {
+		return nil, ErrRepoNotFound
+	}
+	b, err := ctx.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	return b.ParseRevision(data)
+}
+
+// ResolveRevisionOfRepo resolve revision spec to the commit of repo
+func ResolveRevisionOfRepo(id uint64, revision Revision) (*Commit, error) {
+	ctx := getContext(id)
+	if ctx == nil {
+		return nil, ErrRepoNotFound
+	}
+	b, err := ctx.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	return b.Resolve(ctx.root, revision)
+}
+
+// DiffRepo list changed files between two revisions of repo
+func DiffRepo(id uint64, from Revision, to Revision, options DiffOptions) ([]models.FileChange, error) {
+	ctx := getContext(id)
+	if ctx == nil {
+		return nil, ErrRepoNotFound
+	}
+	b, err := ctx.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	return b.Diff(ctx.root, from, to, options)
+}
+
+// DiffRepoTrees compare working trees of two repos under the same dir path recursively
+func DiffRepoTrees(fromID uint64, toID uint64, options DiffOptions) ([]models.TreeFileChange, error) {
+	fromFiles, err := walkRepoTree(fromID, options.Path)
+	if err != nil {
+		return nil, err
+	}
+	toFiles, err := walkRepoTree(toID, options.Path)
+	if err != nil {
+		return nil, err
+	}
+	var relPaths []string
+	for relPath := range fromFiles {
+		relPaths = append(relPaths, relPath)
+	}
+	for relPath := range toFiles {
+		if _, ok := fromFiles[relPath]; !ok {
+			relPaths = append(relPaths, relPath)
+		}
+	}
+	sort.Strings(relPaths)
+	maxPatchSize := cfg.Global().MaxRawFileSize
+	changes := make([]models.TreeFileChange, 0)
+	for _, relPath := range relPaths {
+		fromFile, toFile := fromFiles[relPath], toFiles[relPath]
+		change := models.TreeFileChange{FileChange: models.FileChange{Path: relPath}}
+		var fromPatchFile, toPatchFile *patchFile
+		if fromFile != nil {
+			if change.OldHash, err = fromFile.hash(); err != nil {
+				return nil, err
+			}
+			change.OldSize = fromFile.info.Size
+		}
+		if toFile != nil {
+			if change.Hash, err = toFile.hash(); err != nil {
+				return nil, err
+			}
+			change.Size = toFile.info.Size
+		}
+		switch {
+		case fromFile == nil:
+			change.Action = models.FileChangeAdd
+		case toFile == nil:
+			change.Action = models.FileChangeDelete
+		case change.OldHash != change.Hash || fromFile.info.Mode != toFile.info.Mode:
+			change.Action = models.FileChangeModify
+		default:
+			continue
+		}
+		if options.Patch && change.OldSize <= maxPatchSize && change.Size <= maxPatchSize {
+			if fromFile != nil {
+				if fromPatchFile, err = fromFile.toPatchFile(relPath, change.OldHash); err != nil {
+					return nil, err
+				}
+			}
+			if toFile != nil {
+				if toPatchFile, err = toFile.toPatchFile(relPath, change.Hash); err != nil {
+					return nil, err
+				}
+			}
+			if change.Patch, err = encodeUnifiedPatch(fromPatchFile, toPatchFile); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
@@ -0,0 +1,80 @@
+package repo
+
+import "errors"
+
+// ErrorCode typed code of repo service errors
+type ErrorCode int
+
+const (
+	ErrorCodeUnknown ErrorCode = iota + 1000
+	ErrorCodeRepoNotFound
+	ErrorCodeRepoUpdating
+	ErrorCodePathNotFound
+	ErrorCodePathForbidden
+	ErrorCodeIsDirectory
+	ErrorCodeFileTooLarge
+	ErrorCodeRevisionNotFound
+	ErrorCodeJobNotFound
+	ErrorCodeJobFinished
+	ErrorCodeQueueFull
+	ErrorCodeRepoLeased
+	ErrorCodeLeaseNotFound
+	ErrorCodeNoIdleRepo
+	ErrorCodeURLForbidden
+)
+
+// Error the error of repo service with typed code
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+// Error message of error
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// newError create a typed error
+func newError(code ErrorCode, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// GetErrorCode get typed code of error, ErrorCodeUnknown if untyped
+func GetErrorCode(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ErrorCodeUnknown
+}
+
+var (
+	// ErrRepoNotFound error of repo not found
+	ErrRepoNotFound = newError(ErrorCodeRepoNotFound, "cannot find repo")
+	// ErrRepoUpdating error of repo in updating status
+	ErrRepoUpdating = newError(ErrorCodeRepoUpdating, "repo is updating")
+	// ErrPathNotFound error of path not found in repo
+	ErrPathNotFound = newError(ErrorCodePathNotFound, "cannot find path in repo")
+	// ErrPathForbidden error of path outside repo or hidden
+	ErrPathForbidden = newError(ErrorCodePathForbidden, "path is forbidden")
+	// ErrIsDirectory error of reading content of a directory
+	ErrIsDirectory = newError(ErrorCodeIsDirectory, "path is a directory")
+	// ErrFileTooLarge error of reading a file larger than max raw file size
+	ErrFileTooLarge = newError(ErrorCodeFileTooLarge, "file is too large")
+	// ErrRevisionNotFound error of branch, tag or commit not found
+	ErrRevisionNotFound = newError(ErrorCodeRevisionNotFound, "cannot find revision")
+	// ErrJobNotFound error of job not found
+	ErrJobNotFound = newError(ErrorCodeJobNotFound, "cannot find job")
+	// ErrJobFinished error of operating a finished job
+	ErrJobFinished = newError(ErrorCodeJobFinished, "job is finished")
+	// ErrQueueFull error of job queue full
+	ErrQueueFull = newError(ErrorCodeQueueFull, "job queue is full")
+	// ErrRepoLeased error of moving a repo leased by others
+	ErrRepoLeased = newError(ErrorCodeRepoLeased, "repo is leased")
+	// ErrLeaseNotFound error of lease not found or expired
+	ErrLeaseNotFound = newError(ErrorCodeLeaseNotFound, "cannot find lease")
+	// ErrNoIdleRepo error of no idle repo to lease and no free slot to clone
+	ErrNoIdleRepo = newError(ErrorCodeNoIdleRepo, "no idle repo to lease")
+	// ErrURLForbidden error of requesting a local path or file url of server
+	ErrURLForbidden = newError(ErrorCodeURLForbidden, "local path or file url is forbidden")
+)
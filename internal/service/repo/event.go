@@ -0,0 +1,105 @@
+package repo
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// eventBufferSize buffered events of each subscriber, events are dropped for slow subscribers
+const eventBufferSize = 64
+
+// EventType type of event
+type EventType string
+
+const (
+	EventRepoCreated   EventType = "repo.created"
+	EventRepoStatus    EventType = "repo.status"
+	EventRepoCommit    EventType = "repo.commit"
+	EventRepoDeleted   EventType = "repo.deleted"
+	EventJobProgress   EventType = "job.progress"
+	EventLeaseAcquired EventType = "lease.acquired"
+	EventLeaseReleased EventType = "lease.released"
+	EventLeaseExpired  EventType = "lease.expired"
+)
+
+// Event an event of repo or job
+type Event struct {
+	// ID is the increasing sequence of event
+	ID     uint64      `json:"id"`
+	Type   EventType   `json:"type"`
+	RepoID uint64      `json:"repoId"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data"`
+}
+
+// RepoStatusEventData data of repo status event
+type RepoStatusEventData struct {
+	Old  Status `json:"old"`
+	New  Status `json:"new"`
+	Desc string `json:"desc"`
+}
+
+// RepoCommitEventData data of repo commit event
+type RepoCommitEventData struct {
+	Old Commit `json:"old"`
+	New Commit `json:"new"`
+}
+
+// subscriber receives events of a repo, or all repos if repo id is 0
+type subscriber struct {
+	repoID uint64
+	ch     chan Event
+}
+
+// subscribers the subscribers of events
+var subscribers = make(map[*subscriber]bool)
+
+// subscribersMu mutex to protect subscribers and lastEventID
+var subscribersMu sync.Mutex
+
+// lastEventID the id of last published event
+var lastEventID uint64 = 0
+
+// Subscribe subscribe events of repo, or all repos if repo id is 0,
+// returns the event channel and the function to unsubscribe
+func Subscribe(repoID uint64) (<-chan Event, func()) {
+	s := &subscriber{repoID: repoID, ch: make(chan Event, eventBufferSize)}
+	subscribersMu.Lock()
+	subscribers[s] = true
+	subscribersMu.Unlock()
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			subscribersMu.Lock()
+			delete(subscribers, s)
+			subscribersMu.Unlock()
+			close(s.ch)
+		})
+	}
+}
+
+// publish publish event to subscribers without blocking
+func publish(t EventType, repoID uint64, data interface{}) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	lastEventID++
+	e := Event{ID: lastEventID, Type: t, RepoID: repoID, Time: time.Now(), Data: data}
+	for s := range subscribers {
+		if s.repoID != 0 && s.repoID != repoID {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			log.Printf("dropped event %d of repo %d as subscriber is slow\n", e.ID, repoID)
+		}
+	}
+}
+
+// publishStatusChange publish repo status event if status changed
+func publishStatusChange(repoID uint64, old Status, r *Repo) {
+	if old != r.Status {
+		publish(EventRepoStatus, repoID, RepoStatusEventData{Old: old, New: r.Status, Desc: r.Desc})
+	}
+}
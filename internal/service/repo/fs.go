@@ -1,21 +1,112 @@
 package repo
 
 import (
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
 	"github.com/utmhikari/repomaster/pkg/util"
+	"io"
+	"io/ioutil"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 )
 
+// vcsDirNames names of vcs metadata dirs, hidden from file apis by default
+var vcsDirNames = map[string]bool{
+	".git": true,
+	".svn": true,
+}
+
+// RawFile an opened file of repo to read raw content
+type RawFile struct {
+	*os.File
+	// Stat is the stat info of file
+	Stat os.FileInfo
+	// Hash is the git blob hash of file content
+	Hash string
+}
+
+// isHiddenName is file name hidden from file apis
+func isHiddenName(name string) bool {
+	return vcsDirNames[name] && !cfg.Global().ExposeVcsDir
+}
+
+// isHiddenRelPath is any part of the slash separated relative path hidden
+func isHiddenRelPath(relPath string) bool {
+	for _, name := range strings.Split(relPath, "/") {
+		if isHiddenName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanRelPath clean user supplied path relative to repo root,
+// rejects paths escaping the root and hidden vcs dirs
+func cleanRelPath(p string) (string, error) {
+	// paths are relative to repo root even with leading slash
+	relPath := path.Clean(strings.TrimLeft(filepath.ToSlash(p), "/"))
+	if relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return "", ErrPathForbidden
+	}
+	if isHiddenRelPath(relPath) {
+		return "", ErrPathForbidden
+	}
+	return relPath, nil
+}
+
+// resolveRepoPath resolve user supplied path to the real path inside the repo root,
+// rejects paths escaping the root (including via symlinks) and hidden vcs dirs
+func resolveRepoPath(id uint64, p string) (string, error) {
+	ctx := getContext(id)
+	if ctx == nil {
+		return "", ErrRepoNotFound
+	}
+	root, err := filepath.EvalSymlinks(ctx.root)
+	if err != nil {
+		return "", ErrRepoNotFound
+	}
+	relPath, err := cleanRelPath(p)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(relPath)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrPathNotFound
+		}
+		return "", err
+	}
+	// check again as symlinks may point to anywhere
+	realRelPath, err := filepath.Rel(root, realPath)
+	if err != nil {
+		return "", ErrPathForbidden
+	}
+	realRelPath = filepath.ToSlash(realRelPath)
+	if realRelPath == ".." || strings.HasPrefix(realRelPath, "../") || isHiddenRelPath(realRelPath) {
+		return "", ErrPathForbidden
+	}
+	return realPath, nil
+}
+
 // GetFileInfoListOfRepo list files of specific repo in specific path
 func GetFileInfoListOfRepo(id uint64, dirPath string) (*[]models.FileInfo, error) {
-	root := getRepoRoot(id)
-	relDirPath := path.Join(root, dirPath)
-	fileInfoList, err := util.ListFilesOfDirectory(relDirPath)
+	realDirPath, err := resolveRepoPath(id, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	fileInfoList, err := util.ListFilesOfDirectory(realDirPath)
 	if err != nil {
 		return nil, err
 	}
 	var files []models.FileInfo
 	for _, fileInfo := range *fileInfoList {
+		if isHiddenName(fileInfo.Name()) {
+			continue
+		}
 		files = append(files, *models.NewFileInfoFromStat(fileInfo))
 	}
 	return &files, nil
@@ -23,11 +114,107 @@ func GetFileInfoListOfRepo(id uint64, dirPath string) (*[]models.FileInfo, error
 
 // GetFileInfoOfRepo get specific file stat of repo
 func GetFileInfoOfRepo(id uint64, filePath string) (*models.FileInfo, error) {
-	root := getRepoRoot(id)
-	relFilePath := path.Join(root, filePath)
-	fileInfo, err := util.GetFileStat(relFilePath)
+	realFilePath, err := resolveRepoPath(id, filePath)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := util.GetFileStat(realFilePath)
 	if err != nil {
 		return nil, err
 	}
 	return models.NewFileInfoFromStat(fileInfo), nil
 }
+
+// OpenRawFileOfRepo open specific file of repo to read raw content, should be closed after use
+func OpenRawFileOfRepo(id uint64, filePath string) (*RawFile, error) {
+	realFilePath, err := resolveRepoPath(id, filePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(realFilePath)
+	if err != nil {
+		return nil, err
+	}
+	rawFile, err := newRawFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return rawFile, nil
+}
+
+// newRawFile check the opened file and compute its blob hash
+func newRawFile(f *os.File) (*RawFile, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, ErrIsDirectory
+	}
+	if maxSize := cfg.Global().MaxRawFileSize; stat.Size() > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes exceeds %d bytes", ErrFileTooLarge, stat.Size(), maxSize)
+	}
+	hasher := plumbing.NewHasher(plumbing.BlobObject, stat.Size())
+	if _, err := io.Copy(hasher, f); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &RawFile{
+		File: f,
+		Stat: stat,
+		Hash: hasher.Sum().String(),
+	}, nil
+}
+
+// ReadFileOfRepo read content of file at revision of repo, from working tree if revision is nil
+func ReadFileOfRepo(id uint64, revision Revision, filePath string) ([]byte, error) {
+	if revision == nil {
+		f, err := OpenRawFileOfRepo(id, filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ioutil.ReadAll(f)
+	}
+	ctx := getContext(id)
+	if ctx == nil {
+		return nil, ErrRepoNotFound
+	}
+	relPath, err := cleanRelPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	reader, size, err := b.Cat(ctx.root, revision, relPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	maxSize := cfg.Global().MaxRawFileSize
+	if size > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes exceeds %d bytes", ErrFileTooLarge, size, maxSize)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrFileTooLarge, maxSize)
+	}
+	return content, nil
+}
+
+// ReadFileByRef read content of file referenced in repo
+func ReadFileByRef(ref models.RepoFileRef) ([]byte, error) {
+	revision, err := ParseRevisionOfRepo(ref.ID, ref.Revision)
+	if err != nil {
+		return nil, err
+	}
+	return ReadFileOfRepo(ref.ID, revision, ref.Path)
+}
@@ -1,271 +1,598 @@
 package repo
 
 import (
+	stdcontext "context"
 	"errors"
 	"fmt"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
 	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"io"
 	"log"
+	"os"
 )
 
 // DefaultGitRemote origin
 var DefaultGitRemote = "origin"
 
-// getGitRepo
-func (c *context) getGitRepo() (*git.Repository, error) {
-	if c.root == "" {
+// gitBackend the git implementation of Backend
+type gitBackend struct{}
+
+func init() {
+	RegisterBackend(&gitBackend{})
+}
+
+// toGitRevision get git revision spec from revision
+func toGitRevision(revision Revision) (models.GitRevision, error) {
+	switch r := revision.(type) {
+	case nil:
+		return models.GitRevision{}, nil
+	case models.GitRevision:
+		return r, nil
+	case *models.GitRevision:
+		if r == nil {
+			return models.GitRevision{}, nil
+		}
+		return *r, nil
+	default:
+		return models.GitRevision{}, errUnexpectedRevision(TypeGit, revision)
+	}
+}
+
+// toGitAuth get git auth method from auth
+func toGitAuth(auth Auth) (transport.AuthMethod, error) {
+	if auth == nil {
+		return nil, nil
+	}
+	authMethod, ok := auth.(transport.AuthMethod)
+	if !ok {
+		return nil, errUnexpectedAuth(TypeGit, auth)
+	}
+	return authMethod, nil
+}
+
+// Type is git
+func (b *gitBackend) Type() Type {
+	return TypeGit
+}
+
+// ParseRevision decodes json of models.GitRevision
+func (b *gitBackend) ParseRevision(data []byte) (Revision, error) {
+	return parseJsonRevision(data, &models.GitRevision{})
+}
+
+// open open git repo at root
+func (b *gitBackend) open(root string) (*git.Repository, error) {
+	if root == "" {
 		return nil, errors.New("cannot get git repo root")
 	}
-	gitRepo, err := git.PlainOpen(c.root)
+	gitRepo, err := openGitRepo(root)
 	if err != nil {
 		return nil, err
 	}
 	return gitRepo, nil
 }
 
-// refreshGitRepo refresh context
-func (c *context) refreshGitRepo() bool {
-	refreshErrPrefix := fmt.Sprintf("cannot refresh %s as git repo! ", c.root)
-	// open repo
-	r, err := c.getGitRepo()
-	if err != nil {
-		log.Printf("%s%s\n", refreshErrPrefix, err.Error())
-		c.SetRepoStatusError(err.Error())
-		return false
-	}
-	// refresh data
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.v.URL == "" {
-		remote, remoteErr := r.Remote(DefaultGitRemote)
-		if remoteErr != nil {
-			log.Printf("failed to get remote origin! %s", remoteErr.Error())
-			c.v.SetStatusError(remoteErr.Error())
-			return false
+// Open checks if root is a git repo
+func (b *gitBackend) Open(root string) error {
+	_, err := b.open(root)
+	return err
+}
+
+// Clone clone git repo and checkout to revision
+func (b *gitBackend) Clone(
+	ctx stdcontext.Context, root string, url string, revision Revision, auth Auth, progress Progress) error {
+	gitRevision, err := toGitRevision(revision)
+	if err != nil {
+		return err
+	}
+	authMethod, err := toGitAuth(auth)
+	if err != nil {
+		return err
+	}
+	progress.SetPhase(JobPhaseClone)
+	var r *git.Repository
+	if cfg.Global().DisableGitMirror {
+		r, err = git.PlainCloneContext(ctx, root, false, &git.CloneOptions{
+			URL:      url,
+			Auth:     authMethod,
+			Progress: io.MultiWriter(os.Stdout, progress),
+		})
+	} else {
+		r, err = cloneGitWithMirror(ctx, root, url, authMethod, io.MultiWriter(os.Stdout, progress))
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("successfully cloned git repo to %s", root)
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	progress.SetPhase(JobPhaseCheckout)
+	return checkoutGitRevision(ctx, r, w, gitRevision, authMethod)
+}
+
+// Head get remote url and head commit of git repo
+func (b *gitBackend) Head(root string) (*Head, error) {
+	r, err := b.open(root)
+	if err != nil {
+		return nil, err
+	}
+	var h Head
+	remote, err := r.Remote(DefaultGitRemote)
+	if err != nil {
+		return nil, err
+	}
+	remoteCfg := remote.Config()
+	if remoteCfg == nil {
+		return nil, errors.New("cannot get remote cfg")
+	}
+	for _, remoteURL := range remoteCfg.URLs {
+		if remoteURL != "" {
+			h.URL = remoteURL
+			break
+		}
+	}
+	if h.URL == "" {
+		return nil, errors.New("cannot get remote url")
+	}
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	if head == nil {
+		return nil, errors.New("head is empty")
+	}
+	headCommit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	h.Commit = newCommitFromGitCommit(headCommit)
+	h.Commit.Ref = head.Name().String()
+	return &h, nil
+}
+
+// Checkout pull git repo and checkout to revision
+func (b *gitBackend) Checkout(
+	ctx stdcontext.Context, root string, revision Revision, auth Auth, progress Progress) error {
+	gitRevision, err := toGitRevision(revision)
+	if err != nil {
+		return err
+	}
+	authMethod, err := toGitAuth(auth)
+	if err != nil {
+		return err
+	}
+	r, err := b.open(root)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	// fetch newest branches and tags, the worktree is moved by checkout
+	log.Printf("fetch repo %s...\n", root)
+	if authMethod == nil {
+		log.Printf("warning! fetching repo %s with no authentication!\n", root)
+	}
+	progress.SetPhase(JobPhasePull)
+	pullCtx, cancel := withTimeout(ctx, cfg.Global().GetPullTimeout())
+	defer cancel()
+	if err = syncGitRepo(pullCtx, root, r, authMethod, progress); err != nil {
+		return err
+	}
+	log.Printf("fetch repo %s successfully\n", root)
+	progress.SetPhase(JobPhaseCheckout)
+	return checkoutGitRevision(ctx, r, w, gitRevision, authMethod)
+}
+
+// Clean reset remote, reset hard and clean untracked files of git repo
+func (b *gitBackend) Clean(root string, url string) error {
+	r, err := b.open(root)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	// reset remote origin
+	_ = r.DeleteRemote(DefaultGitRemote)
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name: DefaultGitRemote,
+		URLs: []string{url},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("successfully reset remote %s of repo %s\n", DefaultGitRemote, root)
+	// reset --hard
+	resetOptions := git.ResetOptions{Mode: git.HardReset}
+	if head, headErr := r.Head(); headErr != nil {
+		log.Printf("warning, cannot get head ref of repo %s\n", root)
+	} else {
+		resetOptions.Commit = head.Hash()
+	}
+	if err := w.Reset(&resetOptions); err != nil {
+		return err
+	}
+	log.Printf("successfully reset hard at repo %s\n", root)
+	// clean -df
+	// TODO: clean all? reset all?
+	if err := w.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return err
+	}
+	log.Printf("successfully cleaned files at repo %s\n", root)
+	return nil
+}
+
+// Diff list changed files between two revisions of git repo, computed from the object store
+func (b *gitBackend) Diff(root string, from Revision, to Revision, options DiffOptions) ([]models.FileChange, error) {
+	r, err := b.open(root)
+	if err != nil {
+		return nil, err
+	}
+	fromTree, err := getGitTree(r, from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := getGitTree(r, to)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	changes, err = object.DetectRenames(changes, nil)
+	if err != nil {
+		return nil, err
+	}
+	fileChanges := make([]models.FileChange, 0)
+	for _, change := range changes {
+		if !isPathUnder(change.From.Name, options.Path) && !isPathUnder(change.To.Name, options.Path) {
+			continue
 		}
-		remoteCfg := remote.Config()
-		if remoteCfg == nil {
-			log.Printf("failed to get config of remote!")
-			c.v.SetStatusError("cannot get remote cfg")
-			return false
+		fileChange, err := newFileChangeFromGitChange(change)
+		if err != nil {
+			return nil, err
 		}
-		for _, remoteURL := range remoteCfg.URLs {
-			if remoteURL != "" {
-				c.v.URL = remoteURL
-				break
+		if options.Patch {
+			patch, err := change.Patch()
+			if err != nil {
+				return nil, err
 			}
+			fileChange.Patch = patch.String()
+		}
+		fileChanges = append(fileChanges, *fileChange)
+	}
+	return fileChanges, nil
+}
+
+// Log list commits of git repo
+func (b *gitBackend) Log(root string, options LogOptions) ([]Commit, error) {
+	r, err := b.open(root)
+	if err != nil {
+		return nil, err
+	}
+	to, err := resolveGitRevision(r, options.To)
+	if err != nil {
+		return nil, err
+	}
+	toCommit, err := r.CommitObject(to)
+	if err != nil {
+		return nil, err
+	}
+	w := newGitLogWalker(r)
+	w.push(toCommit)
+	if options.From != nil {
+		from, err := resolveGitRevision(r, options.From)
+		if err != nil {
+			return nil, err
 		}
-		if c.v.URL == "" {
-			log.Printf("failed to get url from remote!")
-			c.v.SetStatusError("cannot get remote url")
-			return false
+		fromCommit, err := r.CommitObject(from)
+		if err != nil {
+			return nil, err
 		}
+		w.exclude(fromCommit.Hash)
+		w.push(fromCommit)
 	}
-	head, headErr := r.Head()
-	if headErr != nil || head == nil {
-		errMsg := "head is empty"
-		if headErr != nil {
-			errMsg = headErr.Error()
+	collector := &logCollector{options: &options}
+	for {
+		c, err := w.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if changed, err := isGitPathChanged(c, options.Path); err != nil {
+			return nil, err
+		} else if !changed {
+			continue
+		}
+		if !collector.add(newCommitFromGitCommit(c)) {
+			break
 		}
-		log.Printf("%sfailed to get head, %s", refreshErrPrefix, errMsg)
-		c.v.SetStatusError(errMsg)
-		return false
 	}
-	c.v.Commit.Ref = head.Name().String()
-	headCommit, err := r.CommitObject(head.Hash())
+	return collector.commits, nil
+}
+
+// Cat open content of file at revision from the object store
+func (b *gitBackend) Cat(root string, revision Revision, filePath string) (io.ReadCloser, int64, error) {
+	r, err := b.open(root)
+	if err != nil {
+		return nil, 0, err
+	}
+	tree, err := getGitTree(r, revision)
 	if err != nil {
-		log.Printf("%sfailed to get head commit, %s", refreshErrPrefix, err.Error())
-		c.v.SetStatusError(err.Error())
-		return false
-	}
-	c.v.Commit.Hash = headCommit.Hash.String()
-	c.v.Commit.Message = headCommit.Message
-	c.v.Commit.Author = headCommit.Author.Name
-	c.v.Commit.Email = headCommit.Author.Email
-	c.v.Type = TypeGit
-	c.v.Status = StatusActive
-	log.Printf("refreshed %s as git repo: %+v\n", c.root, c.v)
-	return true
+		return nil, 0, err
+	}
+	f, err := tree.File(filePath)
+	if err == object.ErrFileNotFound || err == object.ErrDirectoryNotFound {
+		return nil, 0, ErrPathNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	reader, err := f.Reader()
+	if err != nil {
+		return nil, 0, err
+	}
+	return reader, f.Size, nil
 }
 
-// checkoutGitRepo checkout git repo to specific revision
-func (c *context) checkoutGitRepo(
-	revision models.GitRevision, auth transport.AuthMethod, isNeededCleanUp bool) bool {
-	// check current status
-	if !c.IsRepoStatusNormal() {
-		curStatus := c.v.Status
-		log.Printf("failed to checkout repo at %s! current status is %s\n",
-			c.root, string(curStatus))
-		return false
-	}
-	c.SetRepoStatus(StatusUpdating)
-	log.Printf("checkout repo at %s to revision %+v...\n", c.root, revision)
-	// init git repo worktree instance
-	r, err := git.PlainOpen(c.root)
-	if err != nil {
-		log.Printf("failed to checkout repo at %s! cannot open repo! %s\n",
-			c.root, err.Error())
-		c.SetRepoStatusError(err.Error())
-		return false
+// Resolve resolve revision to commit, expressions and abbreviated hashes are supported
+func (b *gitBackend) Resolve(root string, revision Revision) (*Commit, error) {
+	r, err := b.open(root)
+	if err != nil {
+		return nil, err
 	}
-	w, err := r.Worktree()
+	h, err := resolveGitRevision(r, revision)
 	if err != nil {
-		log.Printf("failed to get worktree of repo %s! %s\n", c.root, err.Error())
-		c.SetRepoStatusError(err.Error())
-		return false
-	}
-	defer c.refreshGitRepo()
-	// check if cleanup is needed
-	if isNeededCleanUp {
-		log.Printf("cleaning up repo at %s...\n", c.root)
-		// reset remote origin
-		_ = r.DeleteRemote(DefaultGitRemote)
-		_, remoteErr := r.CreateRemote(&config.RemoteConfig{
-			Name: DefaultGitRemote,
-			URLs: []string{c.v.URL},
-		})
-		if remoteErr != nil {
-			log.Printf("failed to reset remote of repo %s! %s\n",
-				c.root, remoteErr.Error())
-			return false
-		}
-		log.Printf("successfully reset remote %s of repo %s\n", DefaultGitRemote, c.root)
-		// reset --hard
-		var resetErr error = nil
-		head, headErr := r.Head()
-		if headErr != nil {
-			log.Printf("warning, cannot get head ref of repo %s\n", c.root)
-			resetErr = w.Reset(&git.ResetOptions{
-				Mode: git.HardReset,
-			})
-		} else {
-			resetErr = w.Reset(&git.ResetOptions{
-				Commit: head.Hash(),
-				Mode: git.HardReset,
-			})
-		}
-		if resetErr != nil {
-			log.Printf("failed to reset hard at repo %s! %s\n", c.root, resetErr.Error())
-			return false
+		return nil, err
+	}
+	c, err := r.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+	commit := newCommitFromGitCommit(c)
+	return &commit, nil
+}
+
+// Blame get the last commit changed each line of file by go-git blame
+func (b *gitBackend) Blame(root string, revision Revision, filePath string) ([]BlameLine, error) {
+	r, err := b.open(root)
+	if err != nil {
+		return nil, err
+	}
+	h, err := resolveGitRevision(r, revision)
+	if err != nil {
+		return nil, err
+	}
+	c, err := r.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.File(filePath)
+	if err == object.ErrFileNotFound || err == object.ErrDirectoryNotFound {
+		return nil, ErrPathNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	maxSize := cfg.Global().MaxRawFileSize
+	if f.Size > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes exceeds %d bytes", ErrFileTooLarge, f.Size, maxSize)
+	}
+	result, err := git.Blame(c, filePath)
+	if err != nil {
+		return nil, err
+	}
+	commits := make(map[plumbing.Hash]*object.Commit)
+	lines := make([]BlameLine, 0, len(result.Lines))
+	for i, l := range result.Lines {
+		lineCommit, ok := commits[l.Hash]
+		if !ok {
+			if lineCommit, err = r.CommitObject(l.Hash); err != nil {
+				return nil, err
+			}
+			commits[l.Hash] = lineCommit
 		}
-		log.Printf("successfully reset hard at repo %s\n", c.root)
-		// clean -df
-		// TODO: clean all? reset all?
-		cleanErr := w.Clean(&git.CleanOptions{
-			Dir: true,
+		lines = append(lines, BlameLine{
+			Line:    i + 1,
+			Text:    l.Text,
+			Hash:    l.Hash.String(),
+			Author:  lineCommit.Author.Name,
+			Email:   lineCommit.Author.Email,
+			Time:    lineCommit.Author.When,
+			Summary: getSummary(lineCommit.Message),
 		})
-		if cleanErr != nil {
-			log.Printf("failed to clean repo at %s! %s\n", c.root, cleanErr.Error())
-			return false
+	}
+	return lines, nil
+}
+
+// newCommitFromGitCommit convert git commit object to Commit
+func newCommitFromGitCommit(c *object.Commit) Commit {
+	return Commit{
+		Hash:    c.Hash.String(),
+		Message: c.Message,
+		Author:  c.Author.Name,
+		Email:   c.Author.Email,
+		Time:    c.Author.When,
+	}
+}
+
+// newFileChangeFromGitChange convert git tree change to models.FileChange
+func newFileChangeFromGitChange(change *object.Change) (*models.FileChange, error) {
+	action, err := change.Action()
+	if err != nil {
+		return nil, err
+	}
+	switch action {
+	case merkletrie.Insert:
+		return &models.FileChange{Action: models.FileChangeAdd, Path: change.To.Name}, nil
+	case merkletrie.Delete:
+		return &models.FileChange{Action: models.FileChangeDelete, Path: change.From.Name}, nil
+	default:
+		if change.From.Name != change.To.Name {
+			return &models.FileChange{
+				Action:  models.FileChangeRename,
+				Path:    change.To.Name,
+				OldPath: change.From.Name,
+			}, nil
 		}
-		log.Printf("successfully cleaned files at repo %s\n", c.root)
+		return &models.FileChange{Action: models.FileChangeModify, Path: change.To.Name}, nil
 	}
-	// pull newest
-	log.Printf("pull repo %s from URL %s...\n", c.root, c.v.URL)
-	var pullErr error = nil
-	if auth == nil {
-		log.Printf("warning! pulling repo %s from %s with no authentication!\n", c.root, c.v.URL)
-		pullErr = w.Pull(&git.PullOptions{
-			RemoteName: DefaultGitRemote,
-		})
-	} else {
-		pullErr = w.Pull(&git.PullOptions{
-			RemoteName: DefaultGitRemote,
-			Auth: auth,
-		})
+}
+
+// resolveGitRevision resolve revision spec to commit hash, priority: commit hash > rev > tag > branch
+func resolveGitRevision(r *git.Repository, revision Revision) (plumbing.Hash, error) {
+	gitRevision, err := toGitRevision(revision)
+	if err != nil {
+		return plumbing.ZeroHash, err
 	}
-	if pullErr != nil && pullErr != git.NoErrAlreadyUpToDate {
-		log.Printf("failed to pull git repo %s --- %s\n", c.root, pullErr.Error())
-		return false
+	var expr string
+	if gitRevision.Hash != "" {
+		expr = gitRevision.Hash
+	} else if gitRevision.Rev != "" {
+		expr = gitRevision.Rev
+	} else if gitRevision.Tag != "" {
+		expr = plumbing.NewTagReferenceName(gitRevision.Tag).String()
+	} else if gitRevision.Branch != "" {
+		// local branch may not exist in a fresh clone, fallback to remote one
+		expr = plumbing.NewBranchReferenceName(gitRevision.Branch).String()
+		if _, err := r.Reference(plumbing.ReferenceName(expr), false); err != nil {
+			expr = plumbing.NewRemoteReferenceName(DefaultGitRemote, gitRevision.Branch).String()
+		}
 	} else {
-		log.Printf("pull repo %s successfully\n", c.root)
-	}
-	// checkout priority: commit hash > tag > branch
-	// no need to set master as default branch
-	// TODO: the value of tag/branch? sliced commit hash?
-	var checkoutErr error = nil
-	if revision.Hash != "" {
-		checkoutErr = w.Checkout(&git.CheckoutOptions{
-			Hash:  plumbing.NewHash(revision.Hash),
-			Force: true,
-		})
-	} else if revision.Tag != "" {
-		checkoutErr = w.Checkout(&git.CheckoutOptions{
-			Branch: plumbing.NewTagReferenceName(revision.Branch),
-			Force:  true,
-		})
-	} else if revision.Branch != "" {
-		checkoutErr = w.Checkout(&git.CheckoutOptions{
-			Branch: plumbing.NewBranchReferenceName(revision.Branch),
-			Force:  true,
-		})
+		expr = plumbing.HEAD.String()
 	}
-	if checkoutErr != nil {
-		log.Printf("failed to checkout repo at %s to revision %+v! %s\n",
-			c.root, revision, checkoutErr.Error())
-		return false
+	h, err := resolveGitExpr(r, expr)
+	if err == plumbing.ErrReferenceNotFound {
+		return plumbing.ZeroHash, fmt.Errorf("%w: %+v", ErrRevisionNotFound, gitRevision)
 	}
-	log.Printf("successfully checkout repo at %s to revision %+v...\n",
-		c.root, revision)
-	// refresh info
-	return true
+	if err != nil {
+		return plumbing.ZeroHash, errors.New(
+			fmt.Sprintf("cannot resolve revision %+v! %s", gitRevision, err.Error()))
+	}
+	return h, nil
 }
 
-// createGitRepo create git repo
-func createGitRepo(ctx *context, options *git.CloneOptions, revision models.GitRevision) bool {
-	// before clone
-	ctx.mu.Lock()
-	ctx.v.URL = options.URL
-	ctx.mu.Unlock()
-	// clone
-	_, err := git.PlainClone(ctx.root, false, options)
-	if err != nil {
-		log.Printf("failed to clone git repo to %s --- %s", ctx.root, err.Error())
-		ctx.SetRepoStatusError(err.Error())
-		return false
-	}
-	log.Printf("successfully cloned git repo to %s", ctx.root)
-	// checkout
-	return ctx.checkoutGitRepo(revision, options.Auth, false)
+// getGitTree get tree of revision
+func getGitTree(r *git.Repository, revision Revision) (*object.Tree, error) {
+	h, err := resolveGitRevision(r, revision)
+	if err != nil {
+		return nil, err
+	}
+	c, err := r.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+	return c.Tree()
 }
 
-// CreateGitRepo create a new git repo, returns the context id
-func CreateGitRepo(options *git.CloneOptions, revision models.GitRevision, isSync bool) uint64 {
-	if options == nil {
-		return 0
-	}
-	log.Printf("clone git repo with params %+v...\n", options)
-	// request new context with updating status, so that the context wouldn't be gced
-	ctx, id := requestNewContextWithID(TypeGit, StatusUpdating)
-	// TODO: trace clone/pull/checkout progress
-	if isSync {
-		if !createGitRepo(ctx, options, revision) {
-			// create failed
-			return 0
+// resolveGitTag resolve tag to the commit it points to, annotated tags are peeled,
+// tags not found locally are fetched from remote
+func resolveGitTag(
+	ctx stdcontext.Context, r *git.Repository, tag string, auth transport.AuthMethod) (plumbing.Hash, error) {
+	name := plumbing.NewTagReferenceName(tag)
+	_, err := r.Reference(name, false)
+	if err == plumbing.ErrReferenceNotFound {
+		log.Printf("tag %s is not found locally, fetching tags...\n", tag)
+		if err = fetchGitRepo(ctx, r, auth, nil); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		_, err = r.Reference(name, false)
+		if err == plumbing.ErrReferenceNotFound {
+			return plumbing.ZeroHash, fmt.Errorf("%w: tag %s is not found in remote %s",
+				ErrRevisionNotFound, tag, DefaultGitRemote)
 		}
-	} else {
-		go createGitRepo(ctx, options, revision)
 	}
-	return id
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	h, err := resolveGitExpr(r, name.String())
+	if err != nil {
+		return plumbing.ZeroHash, errors.New(fmt.Sprintf("cannot get commit of tag %s! %s", tag, err.Error()))
+	}
+	return h, nil
 }
 
-// UpdateGitRepo update an existed git repo
-func UpdateGitRepo(id uint64, revision models.GitRevision, auth transport.AuthMethod, isSync bool) error {
-	ctx := getContext(id)
-	if ctx == nil {
-		return errors.New(fmt.Sprintf("cannot get repo with ID %d", id))
-	}
-	if isSync {
-		if !ctx.checkoutGitRepo(revision, auth, true) {
-			return errors.New("checkout git repo failed")
+// checkoutGitBranch checkout local branch which follows the remote one, creates it if not existed
+func checkoutGitBranch(r *git.Repository, w *git.Worktree, branch string) error {
+	localName := plumbing.NewBranchReferenceName(branch)
+	remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName(DefaultGitRemote, branch), true)
+	if err == plumbing.ErrReferenceNotFound {
+		if _, err = r.Reference(localName, false); err == plumbing.ErrReferenceNotFound {
+			return fmt.Errorf("%w: branch %s is not found in remote %s",
+				ErrRevisionNotFound, branch, DefaultGitRemote)
 		}
-	} else {
-		go func() {
-			ctx.checkoutGitRepo(revision, auth, true)
-		}()
+	} else if err == nil {
+		// no commits are made in managed repos, so the local branch is simply moved to the remote one
+		err = r.Storer.SetReference(plumbing.NewHashReference(localName, remoteRef.Hash()))
 	}
-	return nil
+	if err != nil {
+		return err
+	}
+	return w.Checkout(&git.CheckoutOptions{
+		Branch: localName,
+		Force:  true,
+	})
+}
+
+// checkoutGitRevision checkout worktree to specific revision, priority: commit hash > rev > tag > branch,
+// the current branch is updated to the remote one if revision is empty
+func checkoutGitRevision(ctx stdcontext.Context,
+	r *git.Repository, w *git.Worktree, revision models.GitRevision, auth transport.AuthMethod) error {
+	var h plumbing.Hash
+	var err error
+	switch {
+	case revision.Hash != "" || revision.Rev != "":
+		// abbreviated hashes and expressions are resolved to commit, checkout as detached head
+		h, err = resolveGitRevision(r, revision)
+	case revision.Tag != "":
+		h, err = resolveGitTag(ctx, r, revision.Tag, auth)
+	case revision.Branch != "":
+		return checkoutGitBranch(r, w, revision.Branch)
+	default:
+		head, err := r.Head()
+		if err != nil {
+			return err
+		}
+		if head.Name().IsBranch() {
+			return checkoutGitBranch(r, w, head.Name().Short())
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return w.Checkout(&git.CheckoutOptions{
+		Hash:  h,
+		Force: true,
+	})
+}
+
+// CreateGitRepo create a new git repo, returns the context id and the clone job
+func CreateGitRepo(
+	options *git.CloneOptions, revision models.GitRevision,
+	labels map[string]string, priority int, isSync bool) (uint64, *Job, error) {
+	if options == nil {
+		return 0, nil, errors.New("clone options is nil")
+	}
+	return CreateRepo(TypeGit, options.URL, revision, options.Auth, labels, priority, isSync)
+}
+
+// UpdateGitRepo update an existed git repo, returns the checkout job
+func UpdateGitRepo(
+	id uint64, revision models.GitRevision, auth transport.AuthMethod, priority int, isSync bool) (*Job, error) {
+	return UpdateRepo(id, TypeGit, revision, auth, priority, isSync)
 }
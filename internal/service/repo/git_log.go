@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"container/heap"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	"path"
+	"strings"
+)
+
+// gitCommitQueue commits to walk, newest committed first
+type gitCommitQueue []*object.Commit
+
+func (q gitCommitQueue) Len() int {
+	return len(q)
+}
+
+func (q gitCommitQueue) Less(i, j int) bool {
+	return q[i].Committer.When.After(q[j].Committer.When)
+}
+
+func (q gitCommitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *gitCommitQueue) Push(x interface{}) {
+	*q = append(*q, x.(*object.Commit))
+}
+
+func (q *gitCommitQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// gitLogWalker walks commits reachable from the included commits but not from the excluded ones
+// in committer time order, like git log from..to. Both sides are walked together, so the walk stops
+// once every pending commit is excluded, e.g. at the merge base, rather than walking the whole history of from.
+// Like git, commits with skewed committer time may be listed even if excluded
+type gitLogWalker struct {
+	r     *git.Repository
+	queue gitCommitQueue
+	// seen commits queued or walked
+	seen map[plumbing.Hash]bool
+	// queued commits in queue
+	queued map[plumbing.Hash]bool
+	// excluded commits reachable from the excluded ones
+	excluded map[plumbing.Hash]bool
+	// included count of queued commits not excluded
+	included int
+}
+
+// newGitLogWalker create a walker of commits of repo
+func newGitLogWalker(r *git.Repository) *gitLogWalker {
+	return &gitLogWalker{
+		r:        r,
+		seen:     make(map[plumbing.Hash]bool),
+		queued:   make(map[plumbing.Hash]bool),
+		excluded: make(map[plumbing.Hash]bool),
+	}
+}
+
+// push queue commit to walk if not seen
+func (w *gitLogWalker) push(c *object.Commit) {
+	if w.seen[c.Hash] {
+		return
+	}
+	w.seen[c.Hash] = true
+	w.queued[c.Hash] = true
+	if !w.excluded[c.Hash] {
+		w.included++
+	}
+	heap.Push(&w.queue, c)
+}
+
+// exclude mark commit and its ancestors to walk as excluded
+func (w *gitLogWalker) exclude(h plumbing.Hash) {
+	if w.excluded[h] {
+		return
+	}
+	w.excluded[h] = true
+	if w.queued[h] {
+		w.included--
+	}
+}
+
+// next get the next included commit, io.EOF if no more
+func (w *gitLogWalker) next() (*object.Commit, error) {
+	for w.included > 0 {
+		c := heap.Pop(&w.queue).(*object.Commit)
+		delete(w.queued, c.Hash)
+		excluded := w.excluded[c.Hash]
+		if !excluded {
+			w.included--
+		}
+		for _, h := range c.ParentHashes {
+			if excluded {
+				w.exclude(h)
+			}
+			if w.seen[h] {
+				continue
+			}
+			p, err := w.r.CommitObject(h)
+			if err != nil {
+				return nil, err
+			}
+			w.push(p)
+		}
+		if !excluded {
+			return c, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// getGitEntryHash get hash of file or dir at path of commit, zero hash if not found
+func getGitEntryHash(c *object.Commit, entryPath string) (plumbing.Hash, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	entry, err := tree.FindEntry(entryPath)
+	if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return entry.Hash, nil
+}
+
+// isGitPathChanged is any file under path changed by commit compared to its first parent
+func isGitPathChanged(c *object.Commit, dirPath string) (bool, error) {
+	dirPath = strings.Trim(path.Clean("/"+dirPath), "/")
+	if dirPath == "" {
+		return true, nil
+	}
+	h, err := getGitEntryHash(c, dirPath)
+	if err != nil {
+		return false, err
+	}
+	parentHash := plumbing.ZeroHash
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return false, err
+		}
+		if parentHash, err = getGitEntryHash(parent, dirPath); err != nil {
+			return false, err
+		}
+	}
+	return h != parentHash, nil
+}
@@ -0,0 +1,157 @@
+package repo
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/utmhikari/repomaster/internal/models"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newGitMergeFixture create the upstream fixture with a merged branch,
+// c1 <- c2 <- c3 <- m5 <- c6 on master and c2 <- f4 -> m5 on branch feature,
+// where f4 adds b/b.txt by another author and the others change a.txt
+func newGitMergeFixture(t *testing.T, dir string) *gitFixture {
+	t.Helper()
+	f := newGitFixture(t, dir)
+	w, err := f.repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile := func() {
+		if err := os.MkdirAll(filepath.Join(f.root, "b"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(f.root, "b", "b.txt"), []byte("feature\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Add("b/b.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// f4 on feature from c2
+	if err := w.Checkout(&git.CheckoutOptions{Hash: f.commits[1], Branch: plumbing.NewBranchReferenceName("feature"),
+		Create: true, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile()
+	other := newGitSignature(4)
+	other.Name, other.Email = "other", "other@example.org"
+	h, err := w.Commit("f4", &git.CommitOptions{Author: other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.commits = append(f.commits, h)
+	// m5 merges feature into master
+	if err := w.Checkout(&git.CheckoutOptions{Branch: plumbing.Master, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile()
+	h, err = w.Commit("m5", &git.CommitOptions{Author: newGitSignature(5), Parents: []plumbing.Hash{f.commits[2], h}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.commits = append(f.commits, h)
+	// c6 on master
+	f.commit(t)
+	return f
+}
+
+func TestGitLogWalksMergedHistory(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitMergeFixture(t, dir)
+	at := func(i int) models.GitRevision {
+		return models.GitRevision{Hash: f.commits[i].String()}
+	}
+	minute := func(i int) time.Time {
+		return newGitSignature(i).When
+	}
+	cases := []struct {
+		name    string
+		options LogOptions
+		expect  []int
+	}{
+		{"all", LogOptions{}, []int{5, 4, 3, 2, 1, 0}},
+		{"to", LogOptions{To: at(2)}, []int{2, 1, 0}},
+		{"from merged side", LogOptions{From: at(2), To: at(5)}, []int{5, 4, 3}},
+		{"from branch side", LogOptions{From: at(3), To: at(5)}, []int{5, 4, 2}},
+		{"from merge base", LogOptions{From: at(1), To: at(4)}, []int{4, 3, 2}},
+		{"from descendant", LogOptions{From: at(5), To: at(3)}, nil},
+		{"from unrelated ref", LogOptions{From: models.GitRevision{Tag: "release"}, To: models.GitRevision{Branch: "feature"}}, []int{3}},
+		{"path of master", LogOptions{Path: "a.txt"}, []int{5, 2, 1, 0}},
+		{"path of merged branch", LogOptions{Path: "b"}, []int{4, 3}},
+		{"path in range", LogOptions{From: at(2), Path: "/b/"}, []int{4, 3}},
+		{"missing path", LogOptions{Path: "c"}, nil},
+		{"author", LogOptions{Author: "OTHER"}, []int{3}},
+		{"author email", LogOptions{Author: "example.com"}, []int{5, 4, 2, 1, 0}},
+		{"since until", LogOptions{Since: minute(3), Until: minute(5)}, []int{4, 3, 2}},
+		{"since in range", LogOptions{From: at(1), Since: minute(4)}, []int{5, 4, 3}},
+		{"first page", LogOptions{Limit: 2}, []int{5, 4}},
+		{"middle page", LogOptions{Skip: 2, Limit: 2}, []int{3, 2}},
+		{"last page", LogOptions{Skip: 4, Limit: 4}, []int{1, 0}},
+		{"beyond last page", LogOptions{Skip: 6, Limit: 2}, nil},
+		{"page of filtered", LogOptions{Path: "a.txt", Author: "tester", Skip: 1, Limit: 2}, []int{2, 1}},
+	}
+	b := getBackend(TypeGit)
+	for _, c := range cases {
+		commits, err := b.Log(f.root, c.options)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err.Error())
+		}
+		var got []string
+		for _, commit := range commits {
+			got = append(got, commit.Hash)
+		}
+		var expect []string
+		for _, i := range c.expect {
+			expect = append(expect, f.commits[i].String())
+		}
+		if len(got) != len(expect) {
+			t.Fatalf("%s: expect commits %v, got %v", c.name, expect, got)
+		}
+		for i := range got {
+			if got[i] != expect[i] {
+				t.Fatalf("%s: expect commits %v, got %v", c.name, expect, got)
+			}
+		}
+	}
+}
+
+func TestGitLogWalkerStopsAtMergeBase(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitMergeFixture(t, dir)
+	load := func(i int) *object.Commit {
+		c, err := f.repo.CommitObject(f.commits[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	// feature..master walks down to the merge base c2 only
+	w := newGitLogWalker(f.repo)
+	w.push(load(5))
+	w.exclude(f.commits[3])
+	w.push(load(3))
+	var walked []plumbing.Hash
+	for {
+		c, err := w.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		walked = append(walked, c.Hash)
+	}
+	if len(walked) != 3 || walked[0] != f.commits[5] || walked[1] != f.commits[4] || walked[2] != f.commits[2] {
+		t.Fatalf("expect c6, m5 and c3 walked, got %v", walked)
+	}
+	if w.seen[f.commits[0]] {
+		t.Fatal("expect history before the merge base not walked")
+	}
+}
@@ -0,0 +1,358 @@
+package repo
+
+import (
+	"bufio"
+	stdcontext "context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	objcache "github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"github.com/utmhikari/repomaster/pkg/util"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// gitMirrorDirName the dir under repo root to store bare mirrors of git urls,
+// a mirror is removed with the last repo sharing its objects, and never pruned otherwise
+const gitMirrorDirName = ".mirrors"
+
+// gitMirrorRefSpecs refspecs to mirror branches and tags of remote
+var gitMirrorRefSpecs = []config.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// gitMirrorLocks locks of mirrors by dir, each mirror is synced by one job at a time
+var gitMirrorLocks sync.Map
+
+// getGitMirrorDir get dir of the bare mirror of url, equivalent urls share the same mirror
+func getGitMirrorDir(url string) string {
+	sum := sha1.Sum([]byte(getURLKey(TypeGit, url)))
+	return filepath.Join(cfg.Global().RepoRoot, gitMirrorDirName, hex.EncodeToString(sum[:])+".git")
+}
+
+// lockGitMirror lock mirror at dir, returns the function to unlock
+func lockGitMirror(dir string) func() {
+	v, _ := gitMirrorLocks.LoadOrStore(dir, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// syncGitMirror create the bare mirror at dir if not existed and fetch branches and tags of url,
+// requires mirror locked
+func syncGitMirror(ctx stdcontext.Context, dir string, url string, auth transport.AuthMethod, progress io.Writer) error {
+	r, err := git.PlainOpen(dir)
+	created := false
+	if err == git.ErrRepositoryNotExists {
+		log.Printf("create git mirror of %s at %s...\n", url, dir)
+		r, err = git.PlainInit(dir, true)
+		created = true
+	}
+	if err != nil {
+		return err
+	}
+	// the url of remote is not stored, as equivalent urls may be fetched with different auth
+	remote := git.NewRemote(r.Storer, &config.RemoteConfig{
+		Name:  DefaultGitRemote,
+		URLs:  []string{url},
+		Fetch: gitMirrorRefSpecs,
+	})
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: DefaultGitRemote,
+		RefSpecs:   gitMirrorRefSpecs,
+		Auth:       auth,
+		Progress:   progress,
+		Tags:       git.NoTags,
+		Force:      true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	// follow the default branch of remote when the mirror is created, or HEAD is dangling as the branch is deleted,
+	// the refs advertised to fetch are not exposed, so they're listed again only then
+	if _, err := r.Reference(plumbing.HEAD, true); created || err != nil {
+		if err := setGitMirrorHead(ctx, r, url, auth); err != nil {
+			return err
+		}
+	}
+	log.Printf("synced git mirror of %s at %s\n", url, dir)
+	return nil
+}
+
+// setGitMirrorHead point HEAD of mirror to the default branch of remote
+func setGitMirrorHead(ctx stdcontext.Context, r *git.Repository, url string, auth transport.AuthMethod) error {
+	refs, err := lsRemoteGit(ctx, url, auth)
+	if err != nil {
+		return err
+	}
+	if refs.Head == "" {
+		return nil
+	}
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(refs.Head))
+	return r.Storer.SetReference(head)
+}
+
+// removeGitMirrorIfUnused remove mirror at dir if no repo shares its objects, e.g. the last one is deleted
+func removeGitMirrorIfUnused(dir string) {
+	if filepath.Dir(dir) != filepath.Join(cfg.Global().RepoRoot, gitMirrorDirName) {
+		log.Printf("refuse to remove unexpected git mirror %s\n", dir)
+		return
+	}
+	if !util.IsDirectory(dir) {
+		return
+	}
+	// repos are created from mirror with it locked, so they either share it already or sync it again
+	unlock := lockGitMirror(dir)
+	defer unlock()
+	used := false
+	cache.Range(func(_, v interface{}) bool {
+		if ctx, ok := v.(*context); ok && getGitMirrorOf(ctx.root) == dir {
+			used = true
+		}
+		return !used
+	})
+	if used {
+		return
+	}
+	log.Printf("remove unused git mirror %s...\n", dir)
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("failed to remove git mirror %s! %s\n", dir, err.Error())
+	}
+}
+
+// removeGitMirrorOfURLIfUnused remove mirror of url if no repo shares its objects,
+// called when the working copy of repo is gone without knowing whether it shared the mirror
+func removeGitMirrorOfURLIfUnused(t Type, url string) {
+	if t == TypeGit && url != "" {
+		removeGitMirrorIfUnused(getGitMirrorDir(url))
+	}
+}
+
+// getGitAlternatesPath get path of alternates file of git repo at root
+func getGitAlternatesPath(root string) string {
+	return filepath.Join(root, git.GitDirName, "objects", "info", "alternates")
+}
+
+// getGitMirrorOf get dir of the mirror that git repo at root shares objects with, empty if not shared
+func getGitMirrorOf(root string) string {
+	f, err := os.Open(getGitAlternatesPath(root))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return ""
+	}
+	objectsDir := strings.TrimSpace(scanner.Text())
+	if !filepath.IsAbs(objectsDir) {
+		return ""
+	}
+	return filepath.Dir(objectsDir)
+}
+
+// copyGitMirrorRefs copy branches and tags of mirror to git repo as the ones fetched from remote
+func copyGitMirrorRefs(mirror *git.Repository, r *git.Repository) error {
+	iter, err := mirror.References()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	return iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		switch {
+		case ref.Name().IsBranch():
+			remoteName := plumbing.NewRemoteReferenceName(DefaultGitRemote, ref.Name().Short())
+			return r.Storer.SetReference(plumbing.NewHashReference(remoteName, ref.Hash()))
+		case ref.Name().IsTag():
+			return r.Storer.SetReference(ref)
+		}
+		return nil
+	})
+}
+
+// cloneGitFromMirror create git repo at root sharing objects of the mirror by alternates,
+// branches and tags of mirror are copied as the ones fetched from url, and the default branch is checked out.
+// Requires mirror locked
+func cloneGitFromMirror(root string, url string, mirrorDir string) (*git.Repository, error) {
+	mirror, err := git.PlainOpen(mirrorDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := git.PlainInit(root, false); err != nil {
+		return nil, err
+	}
+	alternatesPath := getGitAlternatesPath(root)
+	if err := os.MkdirAll(filepath.Dir(alternatesPath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	alternates := filepath.Join(mirrorDir, "objects") + "\n"
+	if err := ioutil.WriteFile(alternatesPath, []byte(alternates), 0644); err != nil {
+		return nil, err
+	}
+	r, err := openGitRepo(root)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.CreateRemote(&config.RemoteConfig{Name: DefaultGitRemote, URLs: []string{url}}); err != nil {
+		return nil, err
+	}
+	if err = copyGitMirrorRefs(mirror, r); err != nil {
+		return nil, err
+	}
+	// checkout the default branch like clone
+	head, err := mirror.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return nil, err
+	}
+	branch := head.Target()
+	target, err := mirror.Reference(branch, true)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.Storer.SetReference(plumbing.NewHashReference(branch, target.Hash())); err != nil {
+		return nil, err
+	}
+	err = r.CreateBranch(&config.Branch{Name: branch.Short(), Remote: DefaultGitRemote, Merge: branch})
+	if err != nil {
+		return nil, err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	if err = w.Checkout(&git.CheckoutOptions{Branch: branch, Force: true}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// cloneGitWithMirror sync the mirror of url and create git repo at root from it
+func cloneGitWithMirror(ctx stdcontext.Context,
+	root string, url string, auth transport.AuthMethod, progress io.Writer) (*git.Repository, error) {
+	dir := getGitMirrorDir(url)
+	unlock := lockGitMirror(dir)
+	defer unlock()
+	if err := syncGitMirror(ctx, dir, url, auth, progress); err != nil {
+		return nil, err
+	}
+	r, err := cloneGitFromMirror(root, url, dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("created git repo at %s from mirror %s\n", root, dir)
+	return r, nil
+}
+
+// syncGitMirrorOfRepo sync the mirror that git repo shares objects with from the remote url of repo,
+// and update branches and tags of repo from the mirror, so that the remote is fetched only once
+func syncGitMirrorOfRepo(ctx stdcontext.Context,
+	r *git.Repository, dir string, auth transport.AuthMethod, progress io.Writer) error {
+	remote, err := r.Remote(DefaultGitRemote)
+	if err != nil {
+		return err
+	}
+	if len(remote.Config().URLs) == 0 {
+		return errors.New("cannot get remote url")
+	}
+	unlock := lockGitMirror(dir)
+	defer unlock()
+	if err := syncGitMirror(ctx, dir, remote.Config().URLs[0], auth, progress); err != nil {
+		return err
+	}
+	mirror, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	return copyGitMirrorRefs(mirror, r)
+}
+
+// openGitRepo open git repo at root, objects of the mirror in alternates are looked up in mirror first
+func openGitRepo(root string) (*git.Repository, error) {
+	mirrorDir := getGitMirrorOf(root)
+	if mirrorDir == "" {
+		return git.PlainOpen(root)
+	}
+	s := filesystem.NewStorage(osfs.New(filepath.Join(root, git.GitDirName)), objcache.NewObjectLRUDefault())
+	mirror := filesystem.NewObjectStorage(dotgit.New(osfs.New(mirrorDir)), objcache.NewObjectLRUDefault())
+	return git.Open(&mirroredStorage{Storage: s, mirror: mirror}, osfs.New(root))
+}
+
+// mirroredStorage storage of git repo sharing objects of mirror.
+// go-git looks up alternates only after local misses and reloads the indexes of mirror every time,
+// so objects are looked up in mirror first here, where most of them are
+type mirroredStorage struct {
+	*filesystem.Storage
+	mirror *filesystem.ObjectStorage
+}
+
+// EncodedObject get object from mirror or local
+func (s *mirroredStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	if obj, err := s.mirror.EncodedObject(t, h); err == nil {
+		return obj, nil
+	}
+	return s.Storage.EncodedObject(t, h)
+}
+
+// HasEncodedObject check if object exists in mirror or local
+func (s *mirroredStorage) HasEncodedObject(h plumbing.Hash) error {
+	if err := s.mirror.HasEncodedObject(h); err == nil {
+		return nil
+	}
+	return s.Storage.HasEncodedObject(h)
+}
+
+// EncodedObjectSize get size of object in mirror or local
+func (s *mirroredStorage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	if size, err := s.mirror.EncodedObjectSize(h); err == nil {
+		return size, nil
+	}
+	return s.Storage.EncodedObjectSize(h)
+}
+
+// IterEncodedObjects iterate objects of mirror and local
+func (s *mirroredStorage) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	mirrorIter, err := s.mirror.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+	localIter, err := s.Storage.IterEncodedObjects(t)
+	if err != nil {
+		mirrorIter.Close()
+		return nil, err
+	}
+	return storer.NewMultiEncodedObjectIter([]storer.EncodedObjectIter{mirrorIter, localIter}), nil
+}
+
+// HashesWithPrefix get hashes of objects with prefix in mirror and local
+func (s *mirroredStorage) HashesWithPrefix(prefix []byte) ([]plumbing.Hash, error) {
+	// pack indexes are loaded lazily by object lookups but not by HashesWithPrefix
+	_ = s.mirror.HasEncodedObject(plumbing.ZeroHash)
+	hashes, err := s.mirror.HashesWithPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.Storage.HasEncodedObject(plumbing.ZeroHash)
+	localHashes, err := s.Storage.HashesWithPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return append(hashes, localHashes...), nil
+}
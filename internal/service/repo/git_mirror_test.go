@@ -0,0 +1,155 @@
+package repo
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/pkg/util"
+	"sync/atomic"
+	"testing"
+)
+
+// createGitRepo create repo of fixture synchronously, deleted after test
+func createGitRepo(t *testing.T, f *gitFixture) uint64 {
+	t.Helper()
+	id, job, err := CreateRepo(TypeGit, f.root, models.GitRevision{}, nil, nil, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == 0 {
+		t.Fatalf("cannot create repo! %+v", job)
+	}
+	t.Cleanup(func() {
+		_ = DeleteRepo(id)
+	})
+	return id
+}
+
+func TestGitMirrorHeadFollowsRemote(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("same"))
+	if err := f.repo.Storer.SetReference(head); err != nil {
+		t.Fatal(err)
+	}
+	id := createGitRepo(t, f)
+	assertGitHead(t, getRepoRoot(id), f.commits[2], "refs/heads/same")
+	mirror, err := git.PlainOpen(getGitMirrorDir(f.root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := mirror.Reference(plumbing.HEAD, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Target() != plumbing.NewBranchReferenceName("same") {
+		t.Fatalf("expect HEAD of mirror at refs/heads/same, got %s", ref.Target())
+	}
+}
+
+func TestGitMirrorRemovedWithLastRepo(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	first := createGitRepo(t, f)
+	second := createGitRepo(t, f)
+	mirrorDir := getGitMirrorDir(f.root)
+	if getGitMirrorOf(getRepoRoot(first)) != mirrorDir || getGitMirrorOf(getRepoRoot(second)) != mirrorDir {
+		t.Fatalf("expect repos sharing mirror %s", mirrorDir)
+	}
+	if err := DeleteRepo(first); err != nil {
+		t.Fatal(err)
+	}
+	if !util.IsDirectory(mirrorDir) {
+		t.Fatal("expect mirror kept while shared by other repos")
+	}
+	if err := DeleteRepo(second); err != nil {
+		t.Fatal(err)
+	}
+	if util.IsDirectory(mirrorDir) {
+		t.Fatal("expect mirror removed with the last repo")
+	}
+	// the mirror is created again by the next clone
+	third := createGitRepo(t, f)
+	assertGitHead(t, getRepoRoot(third), f.commits[2], "refs/heads/master")
+}
+
+// countingTransport transport counting upload pack sessions, i.e. fetches and ls-remotes of remote
+type countingTransport struct {
+	transport.Transport
+	sessions int32
+}
+
+func (c *countingTransport) NewUploadPackSession(ep *transport.Endpoint,
+	auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	atomic.AddInt32(&c.sessions, 1)
+	return c.Transport.NewUploadPackSession(ep, auth)
+}
+
+// countFileSessions count sessions to local remotes until test ends
+func countFileSessions(t *testing.T) *countingTransport {
+	c := &countingTransport{Transport: file.DefaultClient}
+	client.InstallProtocol("file", c)
+	t.Cleanup(func() {
+		client.InstallProtocol("file", file.DefaultClient)
+	})
+	return c
+}
+
+func TestGitMirrorCheckoutFetchesRemoteOnce(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	id := createGitRepo(t, f)
+	c4 := f.commit(t)
+	if err := f.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("next"), c4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.repo.CreateTag("v4", c4, nil); err != nil {
+		t.Fatal(err)
+	}
+	counter := countFileSessions(t)
+	job, err := UpdateRepo(id, TypeGit, models.GitRevision{Branch: "next"}, nil, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Error != "" {
+		t.Fatalf("cannot checkout new branch! %s", job.Error)
+	}
+	assertGitHead(t, getRepoRoot(id), c4, "refs/heads/next")
+	if counter.sessions != 1 {
+		t.Fatalf("expect remote fetched once by checkout, got %d sessions", counter.sessions)
+	}
+
+	// refs of remote are updated from mirror as well
+	refs, err := ListGitRefs(id, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.sessions != 2 {
+		t.Fatalf("expect remote fetched once by listing refs, got %d sessions", counter.sessions-1)
+	}
+	var hasTag bool
+	for _, tag := range refs.Tags {
+		hasTag = hasTag || tag.Ref == "refs/tags/v4" && tag.Hash == c4.String()
+	}
+	if !hasTag {
+		t.Fatalf("expect tag v4 fetched, got %+v", refs.Tags)
+	}
+}
+
+func TestGitMirrorRemovedWithFailedClone(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	id, job, err := CreateRepo(TypeGit, f.root, models.GitRevision{Branch: "missing"}, nil, nil, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 0 || job.Error == "" {
+		t.Fatalf("expect clone of missing branch failed, got repo %d", id)
+	}
+	if util.IsDirectory(getGitMirrorDir(f.root)) {
+		t.Fatal("expect mirror removed with the half-cloned repo")
+	}
+}
@@ -0,0 +1,231 @@
+package repo
+
+import (
+	stdcontext "context"
+	"errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/utmhikari/repomaster/internal/models"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// gitFixture a local upstream git repo with commits c1 <- c2 <- c3 on master,
+// lightweight tag same -> c1, annotated tag release -> c2, and branch same -> c3
+type gitFixture struct {
+	root    string
+	repo    *git.Repository
+	commits []plumbing.Hash
+}
+
+// newGitSignature get signature of fixture commits at the i-th minute
+func newGitSignature(i int) *object.Signature {
+	return &object.Signature{
+		Name:  "tester",
+		Email: "tester@example.com",
+		When:  time.Date(2020, 1, 1, 0, i, 0, 0, time.UTC),
+	}
+}
+
+// commit commit a new version of file a.txt
+func (f *gitFixture) commit(t *testing.T) plumbing.Hash {
+	t.Helper()
+	w, err := f.repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := len(f.commits) + 1
+	content := []byte("version " + strconv.Itoa(i) + "\n")
+	if err := ioutil.WriteFile(filepath.Join(f.root, "a.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	h, err := w.Commit("c"+strconv.Itoa(i), &git.CommitOptions{Author: newGitSignature(i)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.commits = append(f.commits, h)
+	return h
+}
+
+// newGitFixture create the upstream fixture repo under dir
+func newGitFixture(t *testing.T, dir string) *gitFixture {
+	t.Helper()
+	root := filepath.Join(dir, "upstream")
+	r, err := git.PlainInit(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &gitFixture{root: root, repo: r}
+	for i := 0; i < 3; i++ {
+		f.commit(t)
+	}
+	if _, err := r.CreateTag("same", f.commits[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.CreateTag("release", f.commits[1], &git.CreateTagOptions{Tagger: newGitSignature(10), Message: "release"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch := plumbing.NewHashReference(plumbing.NewBranchReferenceName("same"), f.commits[2])
+	if err := r.Storer.SetReference(branch); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// cloneGitFixture clone fixture by git backend at revision
+func cloneGitFixture(t *testing.T, f *gitFixture, root string, revision models.GitRevision) {
+	t.Helper()
+	if err := getBackend(TypeGit).Clone(stdcontext.Background(), root, f.root, revision, nil, nopProgress{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertGitHead assert head commit and ref of git repo at root
+func assertGitHead(t *testing.T, root string, hash plumbing.Hash, ref string) {
+	t.Helper()
+	head, err := getBackend(TypeGit).Head(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Commit.Hash != hash.String() || head.Commit.Ref != ref {
+		t.Fatalf("expect head at %s of %s, got %s of %s", hash, ref, head.Commit.Hash, head.Commit.Ref)
+	}
+}
+
+func TestGitCheckoutTagsAndBranch(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	root := filepath.Join(dir, "clone")
+	b := getBackend(TypeGit)
+	ctx := stdcontext.Background()
+
+	// lightweight tag, detached
+	cloneGitFixture(t, f, root, models.GitRevision{Tag: "same"})
+	assertGitHead(t, root, f.commits[0], plumbing.HEAD.String())
+
+	// annotated tag is peeled to its commit
+	if err := b.Checkout(ctx, root, models.GitRevision{Tag: "release"}, nil, nopProgress{}); err != nil {
+		t.Fatal(err)
+	}
+	assertGitHead(t, root, f.commits[1], plumbing.HEAD.String())
+
+	// branch with the same name as the lightweight tag
+	if err := b.Checkout(ctx, root, models.GitRevision{Branch: "same"}, nil, nopProgress{}); err != nil {
+		t.Fatal(err)
+	}
+	assertGitHead(t, root, f.commits[2], "refs/heads/same")
+
+	// missing tag
+	err := b.Checkout(ctx, root, models.GitRevision{Tag: "missing"}, nil, nopProgress{})
+	if !errors.Is(err, ErrRevisionNotFound) {
+		t.Fatalf("expect revision not found for missing tag, got %v", err)
+	}
+	assertGitHead(t, root, f.commits[2], "refs/heads/same")
+}
+
+func TestResolveGitRevisionTagBeforeBranch(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	root := filepath.Join(dir, "clone")
+	cloneGitFixture(t, f, root, models.GitRevision{})
+	r, err := openGitRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		revision models.GitRevision
+		want     plumbing.Hash
+	}{
+		{models.GitRevision{Tag: "same", Branch: "same"}, f.commits[0]},
+		{models.GitRevision{Rev: "same"}, f.commits[0]},
+		{models.GitRevision{Branch: "same"}, f.commits[2]},
+		{models.GitRevision{Tag: "release"}, f.commits[1]},
+		{models.GitRevision{Hash: f.commits[1].String()[:7], Tag: "same"}, f.commits[1]},
+	}
+	for _, c := range cases {
+		h, err := resolveGitRevision(r, c.revision)
+		if err != nil {
+			t.Fatalf("cannot resolve %+v! %s", c.revision, err.Error())
+		}
+		if h != c.want {
+			t.Fatalf("expect %+v resolved to %s, got %s", c.revision, c.want, h)
+		}
+	}
+}
+
+func TestResolveGitTagFetchesMissingTag(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	root := filepath.Join(dir, "clone")
+	cloneGitFixture(t, f, root, models.GitRevision{})
+	// tags created in upstream after clone
+	c4 := f.commit(t)
+	if _, err := f.repo.CreateTag("late", c4, &git.CreateTagOptions{Tagger: newGitSignature(11), Message: "late"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := openGitRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reference(plumbing.NewTagReferenceName("late"), false); err != plumbing.ErrReferenceNotFound {
+		t.Fatalf("expect tag late not cloned, got %v", err)
+	}
+	ctx := stdcontext.Background()
+	h, err := resolveGitTag(ctx, r, "late", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != c4 {
+		t.Fatalf("expect tag late fetched at %s, got %s", c4, h)
+	}
+	if _, err := resolveGitTag(ctx, r, "missing", nil); !errors.Is(err, ErrRevisionNotFound) {
+		t.Fatalf("expect revision not found for missing tag, got %v", err)
+	}
+}
+
+func TestResolveGitExprRefBeforeHashPrefix(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	// hex-looking branch and tag named after abbreviated hash of other commits
+	branch := f.commits[0].String()[:7]
+	tag := f.commits[1].String()[:6]
+	if err := f.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), f.commits[2])); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("cafe"), f.commits[1])); err != nil {
+		t.Fatal(err)
+	}
+	_, err := f.repo.CreateTag(tag, f.commits[2], &git.CreateTagOptions{Tagger: newGitSignature(12), Message: tag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		expr string
+		want plumbing.Hash
+	}{
+		{branch, f.commits[2]},
+		{branch + "~1", f.commits[1]},
+		{tag, f.commits[2]},
+		{"cafe", f.commits[1]},
+		{f.commits[0].String()[:8], f.commits[0]},
+		{f.commits[1].String()[:7] + "^", f.commits[0]},
+		{f.commits[0].String(), f.commits[0]},
+	}
+	for _, c := range cases {
+		h, err := resolveGitExpr(f.repo, c.expr)
+		if err != nil {
+			t.Fatalf("cannot resolve %s! %s", c.expr, err.Error())
+		}
+		if h != c.want {
+			t.Fatalf("expect %s resolved to %s, got %s", c.expr, c.want, h)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"github.com/utmhikari/repomaster/pkg/util"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultURLPorts default ports of url schemes, which are dropped from normalized urls
+var defaultURLPorts = map[string]string{
+	"http":    "80",
+	"https":   "443",
+	"ssh":     "22",
+	"git+ssh": "22",
+	"svn+ssh": "22",
+	"git":     "9418",
+	"svn":     "3690",
+}
+
+// scpURLPattern pattern of scp-like ssh url, e.g. git@github.com:user/repo.git
+var scpURLPattern = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// NormalizeURL normalize remote url so that equivalent ones are the same, e.g.
+// git@github.com:user/repo.git, ssh://git@github.com/user/repo and https://github.com/user/repo/
+// are all github.com/user/repo, and file:///path/to/repo is /path/to/repo.
+// Ports other than the default one of scheme are kept, as they're different servers
+func NormalizeURL(t Type, rawURL string) string {
+	u := strings.TrimRight(strings.TrimSpace(rawURL), "/")
+	if t == TypeGit {
+		u = strings.TrimRight(strings.TrimSuffix(u, ".git"), "/")
+	}
+	if !strings.Contains(u, "://") {
+		// single letter host is the drive of windows path, e.g. C:/path/to/repo
+		if m := scpURLPattern.FindStringSubmatch(u); m != nil && len(m[1]) > 1 {
+			return strings.ToLower(m[1]) + "/" + strings.TrimLeft(m[2], "/")
+		}
+		// local path
+		return u
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	if parsed.Scheme == "file" {
+		return parsed.Path
+	}
+	// user info, default port and scheme are dropped
+	host := strings.ToLower(parsed.Hostname())
+	if port := parsed.Port(); port != "" && port != defaultURLPorts[strings.ToLower(parsed.Scheme)] {
+		host += ":" + port
+	}
+	return host + "/" + strings.TrimLeft(parsed.Path, "/")
+}
+
+// validateURL check remote url of repo requested by users, which is shared by all apis taking urls.
+// Urls like options are rejected as they would be parsed as options by commands,
+// and local paths and file urls are forbidden unless allowed by config
+func validateURL(rawURL string) error {
+	u := strings.TrimSpace(rawURL)
+	if u == "" || strings.HasPrefix(u, "-") {
+		return errors.New(fmt.Sprintf("invalid url %s", rawURL))
+	}
+	if !cfg.Global().AllowLocalURL && util.IsLocalURL(u) {
+		return ErrURLForbidden
+	}
+	return nil
+}
+
+// validateRevision check revision of repo requested by users before queued
+func validateRevision(t Type, revision Revision) error {
+	if t == TypeSvn {
+		_, err := toSvnRevision(revision)
+		return err
+	}
+	return nil
+}
+
+// indexEntry the indexed keys of a repo
+type indexEntry struct {
+	urlKey string
+	hash   string
+	ref    string
+}
+
+// repoIndex index of repos by type and normalized url, and by head hash or ref additionally
+type repoIndex struct {
+	mu      sync.RWMutex
+	entries map[uint64]indexEntry
+	byURL   map[string]map[uint64]bool
+	byHash  map[string]map[uint64]bool
+	byRef   map[string]map[uint64]bool
+}
+
+// newRepoIndex create an empty repo index
+func newRepoIndex() *repoIndex {
+	return &repoIndex{
+		entries: make(map[uint64]indexEntry),
+		byURL:   make(map[string]map[uint64]bool),
+		byHash:  make(map[string]map[uint64]bool),
+		byRef:   make(map[string]map[uint64]bool),
+	}
+}
+
+// index the global repo index, updated whenever url or head commit of repo changes
+var index = newRepoIndex()
+
+// getURLKey get index key of type and url
+func getURLKey(t Type, url string) string {
+	return string(t) + "|" + NormalizeURL(t, url)
+}
+
+// addToSet add id to set of key
+func addToSet(sets map[string]map[uint64]bool, key string, id uint64) {
+	set, ok := sets[key]
+	if !ok {
+		set = make(map[uint64]bool)
+		sets[key] = set
+	}
+	set[id] = true
+}
+
+// removeFromSet remove id from set of key
+func removeFromSet(sets map[string]map[uint64]bool, key string, id uint64) {
+	if set, ok := sets[key]; ok {
+		delete(set, id)
+		if len(set) == 0 {
+			delete(sets, key)
+		}
+	}
+}
+
+// sortedIDs get ids of set in order
+func sortedIDs(set map[uint64]bool) []uint64 {
+	ids := make([]uint64, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// removeLocked remove indexed keys of repo, requires mu locked
+func (x *repoIndex) removeLocked(id uint64) {
+	e, ok := x.entries[id]
+	if !ok {
+		return
+	}
+	removeFromSet(x.byURL, e.urlKey, id)
+	if e.hash != "" {
+		removeFromSet(x.byHash, e.urlKey+"|"+e.hash, id)
+	}
+	if e.ref != "" {
+		removeFromSet(x.byRef, e.urlKey+"|"+e.ref, id)
+	}
+	delete(x.entries, id)
+}
+
+// put index repo by id, replacing the keys indexed before
+func (x *repoIndex) put(id uint64, r Repo) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+	if r.URL == "" {
+		return
+	}
+	e := indexEntry{urlKey: getURLKey(r.Type, r.URL), hash: r.Commit.Hash, ref: r.Commit.Ref}
+	x.entries[id] = e
+	addToSet(x.byURL, e.urlKey, id)
+	if e.hash != "" {
+		addToSet(x.byHash, e.urlKey+"|"+e.hash, id)
+	}
+	if e.ref != "" {
+		addToSet(x.byRef, e.urlKey+"|"+e.ref, id)
+	}
+}
+
+// remove remove repo from index
+func (x *repoIndex) remove(id uint64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+}
+
+// findByURL find ids of repos of type and url
+func (x *repoIndex) findByURL(t Type, url string) []uint64 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return sortedIDs(x.byURL[getURLKey(t, url)])
+}
+
+// findByHash find ids of repos of type and url at hash, exact matches come first,
+// followed by repos whose git hash starts with the abbreviated one
+func (x *repoIndex) findByHash(t Type, url string, hash string) []uint64 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	urlKey := getURLKey(t, url)
+	ids := sortedIDs(x.byHash[urlKey+"|"+hash])
+	if t != TypeGit {
+		return ids
+	}
+	for _, id := range sortedIDs(x.byURL[urlKey]) {
+		if e := x.entries[id]; e.hash != hash && isGitHashMatched(e.hash, hash) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// findByRef find ids of repos of type and url at ref
+func (x *repoIndex) findByRef(t Type, url string, ref string) []uint64 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return sortedIDs(x.byRef[getURLKey(t, url)+"|"+ref])
+}
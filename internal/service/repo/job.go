@@ -0,0 +1,405 @@
+package repo
+
+import (
+	"bytes"
+	stdcontext "context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxFinishedJobsPerRepo max finished jobs kept for each repo, older ones are dropped
+const maxFinishedJobsPerRepo = 20
+
+// JobType type of job
+type JobType string
+
+const (
+	JobTypeClone    JobType = "clone"
+	JobTypeCheckout JobType = "checkout"
+	JobTypeFetch    JobType = "fetch"
+)
+
+// JobStatus status of job
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "pending"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+	JobStatusCanceled  JobStatus = "canceled"
+)
+
+// IsDone is job finished
+func (s JobStatus) IsDone() bool {
+	return s == JobStatusSucceeded || s == JobStatusFailed || s == JobStatusCanceled
+}
+
+// JobPhase the step a job is running
+type JobPhase string
+
+const (
+	JobPhasePending  JobPhase = "pending"
+	JobPhaseClone    JobPhase = "clone"
+	JobPhaseClean    JobPhase = "clean"
+	JobPhasePull     JobPhase = "pull"
+	JobPhaseCheckout JobPhase = "checkout"
+	JobPhaseRefresh  JobPhase = "refresh"
+	JobPhaseDone     JobPhase = "done"
+)
+
+// JobProgress the latest progress reported by remote, e.g. "Receiving objects:  50% (10/20)"
+type JobProgress struct {
+	// Stage is the stage of remote progress, e.g. Counting objects
+	Stage string `json:"stage"`
+	// Percent is the percentage of stage, -1 if unknown
+	Percent int `json:"percent"`
+	// Current is the count of objects done in stage
+	Current int64 `json:"current"`
+	// Total is the count of objects in stage
+	Total int64 `json:"total"`
+	// Message is the raw message of latest progress
+	Message string `json:"message"`
+}
+
+// Job an asynchronous clone or checkout of repo
+type Job struct {
+	ID       uint64      `json:"id"`
+	RepoID   uint64      `json:"repoId"`
+	Type     JobType     `json:"type"`
+	Status   JobStatus   `json:"status"`
+	Phase    JobPhase    `json:"phase"`
+	Progress JobProgress `json:"progress"`
+	// Revision is the revision requested
+	Revision Revision `json:"revision"`
+	// Result is the head commit of repo after job succeeded
+	Result *Commit `json:"result"`
+	Error  string  `json:"error"`
+	// Timeout is the timeout of job in seconds since started, 0 if no timeout
+	Timeout int64 `json:"timeout"`
+	// Priority is the priority in queue, higher ones run first
+	Priority int `json:"priority"`
+	// QueuePosition is the 1-based position in queue while pending, 0 if not queued
+	QueuePosition int `json:"queuePosition"`
+
+	CreatedAt time.Time  `json:"createdAt"`
+	StartedAt *time.Time `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt"`
+}
+
+// Progress receives phases and remote progress of a running job
+type Progress interface {
+	// Write receives sideband progress messages of remote
+	Write(p []byte) (int, error)
+	// SetPhase sets the current phase
+	SetPhase(phase JobPhase)
+}
+
+// progressPattern pattern of remote progress message with percentage
+var progressPattern = regexp.MustCompile(`^([^:]+):\s+(\d+)% \((\d+)/(\d+)\)`)
+
+// jobContext the job context in repomaster runtime
+type jobContext struct {
+	// mu mutex to protect job instance
+	mu sync.RWMutex
+	// v the job instance
+	v Job
+	// buf incomplete progress message
+	buf []byte
+	// ctx the context to cancel job, with timeout since started
+	ctx stdcontext.Context
+	// cancel cancels ctx
+	cancel stdcontext.CancelFunc
+}
+
+// snapshot get a copy of job with lock
+func (j *jobContext) snapshot() Job {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.v
+}
+
+// snapshotWithPosition get a copy of job with its position in queue if pending
+func (j *jobContext) snapshotWithPosition() Job {
+	v := j.snapshot()
+	if v.Status == JobStatusPending {
+		v.QueuePosition = workers.position(v.ID)
+	}
+	return v
+}
+
+// publish publish job progress event with snapshot of job
+func (j *jobContext) publish() {
+	v := j.snapshot()
+	publish(EventJobProgress, v.RepoID, v)
+}
+
+// start set job running, returns the context of job with timeout
+func (j *jobContext) start() stdcontext.Context {
+	now := time.Now()
+	j.mu.Lock()
+	j.v.Status = JobStatusRunning
+	j.v.StartedAt = &now
+	if j.v.Timeout > 0 {
+		// the timeout context is derived from the job context, and both are canceled by cancel,
+		// so that canceling by the cancel read before start still works
+		ctx, cancelTimeout := stdcontext.WithTimeout(j.ctx, time.Duration(j.v.Timeout)*time.Second)
+		cancel := j.cancel
+		j.ctx = ctx
+		j.cancel = func() {
+			cancelTimeout()
+			cancel()
+		}
+	}
+	ctx := j.ctx
+	j.mu.Unlock()
+	j.publish()
+	return ctx
+}
+
+// finish set job done with result commit or error, canceled if the context of job is canceled
+func (j *jobContext) finish(result *Commit, err error) {
+	now := time.Now()
+	j.mu.Lock()
+	if j.v.StartedAt == nil {
+		j.v.StartedAt = &now
+	}
+	j.v.EndedAt = &now
+	j.v.Phase = JobPhaseDone
+	ctxErr := j.ctx.Err()
+	if err != nil && ctxErr == stdcontext.Canceled {
+		j.v.Status = JobStatusCanceled
+		j.v.Error = "job is canceled! " + err.Error()
+	} else if err != nil && ctxErr == stdcontext.DeadlineExceeded {
+		j.v.Status = JobStatusFailed
+		j.v.Error = fmt.Sprintf("job timed out after %ds! %s", j.v.Timeout, err.Error())
+	} else if err != nil {
+		j.v.Status = JobStatusFailed
+		j.v.Error = err.Error()
+	} else {
+		j.v.Status = JobStatusSucceeded
+		j.v.Result = result
+	}
+	j.mu.Unlock()
+	// release resources of context
+	j.cancel()
+	j.publish()
+}
+
+// cancelJob cancel the context of job if not finished
+func (j *jobContext) cancelJob() error {
+	j.mu.RLock()
+	status, cancel := j.v.Status, j.cancel
+	j.mu.RUnlock()
+	if status.IsDone() {
+		return ErrJobFinished
+	}
+	cancel()
+	return nil
+}
+
+// SetPhase set current phase of job, progress of the last phase is reset
+func (j *jobContext) SetPhase(phase JobPhase) {
+	j.mu.Lock()
+	j.v.Phase = phase
+	j.v.Progress = JobProgress{Percent: -1}
+	j.mu.Unlock()
+	j.publish()
+}
+
+// Write parse sideband progress messages separated by CR or LF, the latest one is kept,
+// progress event is published only if stage or percentage changed
+func (j *jobContext) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	changed := false
+	j.buf = append(j.buf, p...)
+	for {
+		i := bytes.IndexAny(j.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		if msg := strings.TrimSpace(string(j.buf[:i])); msg != "" {
+			progress := parseProgress(msg)
+			if progress.Stage != j.v.Progress.Stage || progress.Percent != j.v.Progress.Percent {
+				changed = true
+			}
+			j.v.Progress = progress
+		}
+		j.buf = j.buf[i+1:]
+	}
+	j.mu.Unlock()
+	if changed {
+		j.publish()
+	}
+	return len(p), nil
+}
+
+// parseProgress parse a sideband progress message
+func parseProgress(msg string) JobProgress {
+	progress := JobProgress{Percent: -1, Message: msg}
+	match := progressPattern.FindStringSubmatch(msg)
+	if match == nil {
+		if i := strings.IndexByte(msg, ':'); i > 0 {
+			progress.Stage = msg[:i]
+		}
+		return progress
+	}
+	progress.Stage = strings.TrimSpace(match[1])
+	progress.Percent, _ = strconv.Atoi(match[2])
+	progress.Current, _ = strconv.ParseInt(match[3], 10, 64)
+	progress.Total, _ = strconv.ParseInt(match[4], 10, 64)
+	return progress
+}
+
+// jobs stores the job contexts by id
+var jobs = make(map[uint64]*jobContext)
+
+// jobsByRepo ids of jobs by repo id in creation order
+var jobsByRepo = make(map[uint64][]uint64)
+
+// jobsMu mutex to protect jobs, jobsByRepo and lastJobID
+var jobsMu sync.RWMutex
+
+// lastJobID the id of last created job
+var lastJobID uint64 = 0
+
+// createJob create a pending job of repo, which times out after timeout since started if positive
+func createJob(repoID uint64, t JobType, revision Revision, timeout time.Duration, priority int) *jobContext {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	lastJobID++
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	j := &jobContext{
+		ctx:    ctx,
+		cancel: cancel,
+		v: Job{
+			ID:        lastJobID,
+			RepoID:    repoID,
+			Type:      t,
+			Status:    JobStatusPending,
+			Phase:     JobPhasePending,
+			Progress:  JobProgress{Percent: -1},
+			Revision:  revision,
+			Timeout:   int64(timeout / time.Second),
+			Priority:  priority,
+			CreatedAt: time.Now(),
+		},
+	}
+	jobs[j.v.ID] = j
+	jobsByRepo[repoID] = append(jobsByRepo[repoID], j.v.ID)
+	gcJobsOfRepo(repoID)
+	return j
+}
+
+// gcJobsOfRepo drop the oldest finished jobs of repo exceeding max count, requires jobsMu locked
+func gcJobsOfRepo(repoID uint64) {
+	ids := jobsByRepo[repoID]
+	finished := 0
+	for _, id := range ids {
+		if jobs[id].snapshot().Status.IsDone() {
+			finished++
+		}
+	}
+	if finished <= maxFinishedJobsPerRepo {
+		return
+	}
+	// ids are in creation order, so the oldest finished ones are dropped first
+	kept := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		if finished > maxFinishedJobsPerRepo && jobs[id].snapshot().Status.IsDone() {
+			delete(jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	jobsByRepo[repoID] = kept
+}
+
+// GetJob get snapshot of job by id
+func GetJob(id uint64) *Job {
+	jobsMu.RLock()
+	j, ok := jobs[id]
+	jobsMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	v := j.snapshotWithPosition()
+	return &v
+}
+
+// removeJob remove job by id
+func removeJob(id uint64) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j, ok := jobs[id]
+	if !ok {
+		return
+	}
+	delete(jobs, id)
+	repoID := j.v.RepoID
+	ids := jobsByRepo[repoID]
+	for i := range ids {
+		if ids[i] == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(jobsByRepo, repoID)
+	} else {
+		jobsByRepo[repoID] = ids
+	}
+}
+
+// removeJobsOfRepo remove all jobs of repo, called when repo is deleted
+func removeJobsOfRepo(repoID uint64) {
+	jobsMu.Lock()
+	for _, id := range jobsByRepo[repoID] {
+		delete(jobs, id)
+	}
+	delete(jobsByRepo, repoID)
+	jobsMu.Unlock()
+}
+
+// CancelJob cancel a pending or running job
+func CancelJob(id uint64) error {
+	jobsMu.RLock()
+	j, ok := jobs[id]
+	jobsMu.RUnlock()
+	if !ok {
+		return ErrJobNotFound
+	}
+	if err := j.cancelJob(); err != nil {
+		return err
+	}
+	// pending job is removed from queue and run at once, which exits as canceled
+	if t := workers.remove(id); t != nil {
+		go func() {
+			defer close(t.done)
+			t.run()
+		}()
+	}
+	log.Printf("canceled job %d\n", id)
+	return nil
+}
+
+// ListJobsOfRepo list snapshots of jobs of repo, newest first
+func ListJobsOfRepo(repoID uint64) []Job {
+	list := make([]Job, 0)
+	jobsMu.RLock()
+	for _, id := range jobsByRepo[repoID] {
+		list = append(list, jobs[id].snapshotWithPosition())
+	}
+	jobsMu.RUnlock()
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].ID > list[k].ID
+	})
+	return list
+}
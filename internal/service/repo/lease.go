@@ -0,0 +1,422 @@
+package repo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// leaseReclaimInterval interval of reclaiming expired leases
+const leaseReclaimInterval = 10 * time.Second
+
+// Lease an exclusive lease of repo, which couldn't be updated or deleted by others until released or expired.
+// The id is the token to renew or release the lease, so it's only exposed to the holder by AcquiredLease
+type Lease struct {
+	ID     string `json:"-"`
+	RepoID uint64 `json:"repoId"`
+	// Root is the local root of the leased working copy
+	Root string `json:"root"`
+	// Holder is the description of lease holder, e.g. the name of ci job
+	Holder string `json:"holder"`
+	// Commit is the head commit of repo when acquired
+	Commit Commit `json:"commit"`
+	// TTL is the seconds to expire since acquired or renewed by heartbeat
+	TTL int64 `json:"ttl"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	RenewedAt time.Time `json:"renewedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// AcquiredLease the lease returned to its holder on acquired, with the id to renew or release it
+type AcquiredLease struct {
+	ID string `json:"id"`
+	Lease
+}
+
+// LeaseOptions options to acquire a lease of repo at hash, or at ref if hash is empty
+type LeaseOptions struct {
+	Type Type
+	URL  string
+	Hash string
+	Ref  string
+	// Revision is the revision to checkout or clone if no idle repo is at hash or ref
+	Revision Revision
+	Auth     Auth
+	Holder   string
+	// TTL is the ttl of lease in seconds, the default one if not positive
+	TTL int64
+	// Clean is whether to clean up the idle repo at hash or ref before leased
+	Clean bool
+	// Labels is the labels of repo if cloned
+	Labels map[string]string
+	// Priority is the priority of checkout or clone job
+	Priority int
+}
+
+// leaseContext the lease context in repomaster runtime
+type leaseContext struct {
+	// v the lease instance
+	v Lease
+	// ready whether the repo is prepared, the lease never expires while the repo is checked out or cloned
+	ready bool
+}
+
+// expired is lease expired at time
+func (l *leaseContext) expired(now time.Time) bool {
+	return l.ready && now.After(l.v.ExpiresAt)
+}
+
+// leaseManager manages leases of repos, each repo could be leased by one holder at most
+type leaseManager struct {
+	mu sync.Mutex
+	// byID leases by id
+	byID map[string]*leaseContext
+	// byRepo leases by repo id
+	byRepo map[uint64]*leaseContext
+	// cloning count of repos to clone for leases by url key, which take slots of the url until indexed
+	cloning map[string]int
+}
+
+// leases the global lease manager
+var leases = &leaseManager{
+	byID:    make(map[string]*leaseContext),
+	byRepo:  make(map[uint64]*leaseContext),
+	cloning: make(map[string]int),
+}
+
+// newLeaseID generate a random lease id, which is the token to renew or release the lease
+func newLeaseID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("failed to generate random lease id! %s\n", err.Error())
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// isLeased is repo leased or reserved by a lease
+func (m *leaseManager) isLeased(repoID uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.byRepo[repoID]
+	return ok && !l.expired(time.Now())
+}
+
+// isHeldByLocked is repo held by lease, or not leased if lease id is empty, requires mu locked
+func (m *leaseManager) isHeldByLocked(repoID uint64, leaseID string) bool {
+	l, ok := m.byRepo[repoID]
+	if !ok || l.expired(time.Now()) {
+		return leaseID == ""
+	}
+	return l.v.ID == leaseID
+}
+
+// tryAcquireRepo acquire updating status of repo by tryAcquire if it's held by lease, or not leased if lease id
+// is empty, returns the previous status, ErrRepoLeased or ErrRepoUpdating.
+// The check and the acquire are atomic with reserving leases, so that a repo just leased wouldn't be mutated
+func (m *leaseManager) tryAcquireRepo(c *context, leaseID string, accept func(status Status) bool) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.isHeldByLocked(c.id, leaseID) {
+		return "", ErrRepoLeased
+	}
+	prevStatus, ok := c.tryAcquire(accept)
+	if !ok {
+		return prevStatus, ErrRepoUpdating
+	}
+	return prevStatus, nil
+}
+
+// reserveLocked reserve repo for a new lease which is not ready, requires mu locked
+func (m *leaseManager) reserveLocked(repoID uint64, holder string, ttl time.Duration) *leaseContext {
+	now := time.Now()
+	l := &leaseContext{
+		v: Lease{
+			ID:        newLeaseID(),
+			RepoID:    repoID,
+			Root:      getRepoRoot(repoID),
+			Holder:    holder,
+			TTL:       int64(ttl / time.Second),
+			CreatedAt: now,
+		},
+	}
+	m.byID[l.v.ID] = l
+	m.byRepo[repoID] = l
+	return l
+}
+
+// removeLocked remove lease, requires mu locked
+func (m *leaseManager) removeLocked(l *leaseContext) {
+	delete(m.byID, l.v.ID)
+	if cur, ok := m.byRepo[l.v.RepoID]; ok && cur == l {
+		delete(m.byRepo, l.v.RepoID)
+	}
+}
+
+// removeRepo remove lease of repo, called when repo is deleted
+func (m *leaseManager) removeRepo(repoID uint64) {
+	m.mu.Lock()
+	l, ok := m.byRepo[repoID]
+	if ok {
+		m.removeLocked(l)
+	}
+	m.mu.Unlock()
+	if ok && l.ready {
+		log.Printf("released lease %s as repo %d is deleted\n", l.v.ID, repoID)
+		publish(EventLeaseReleased, repoID, l.v)
+	}
+}
+
+// reclaim remove expired leases so that the repos could be leased again
+func (m *leaseManager) reclaim() {
+	now := time.Now()
+	var expired []Lease
+	m.mu.Lock()
+	for _, l := range m.byID {
+		if l.expired(now) {
+			m.removeLocked(l)
+			expired = append(expired, l.v)
+		}
+	}
+	m.mu.Unlock()
+	for _, v := range expired {
+		log.Printf("reclaimed expired lease %s of repo %d held by %s\n", v.ID, v.RepoID, v.Holder)
+		publish(EventLeaseExpired, v.RepoID, v)
+	}
+}
+
+// reserveIdleLocked reserve an idle active repo of url for a new lease, which is at hash or ref preferably,
+// returns nil if no idle repo, requires mu locked
+func (m *leaseManager) reserveIdleLocked(opts LeaseOptions, ttl time.Duration) (l *leaseContext, isAt bool) {
+	now := time.Now()
+	// repos with queued jobs are not idle either, as the jobs would move them
+	idle := func(id uint64) bool {
+		cur, ok := m.byRepo[id]
+		return (!ok || cur.expired(now)) && workers.repoPosition(id) == 0
+	}
+	var id uint64
+	if opts.Hash != "" {
+		id, _ = findActiveRepo(index.findByHash(opts.Type, opts.URL, opts.Hash), opts.Type, opts.URL,
+			func(id uint64, r *Repo) bool {
+				return isRepoAtHash(r, opts.Hash) && idle(id)
+			})
+	} else {
+		id, _ = findActiveRepo(index.findByRef(opts.Type, opts.URL, opts.Ref), opts.Type, opts.URL,
+			func(id uint64, r *Repo) bool {
+				return r.Commit.Ref == opts.Ref && idle(id)
+			})
+	}
+	if id != 0 {
+		return m.reserveLocked(id, opts.Holder, ttl), true
+	}
+	// members of pre-warmed pools are kept at their refs, so they're not checked out to other revisions
+	id, _ = findActiveRepo(index.findByURL(opts.Type, opts.URL), opts.Type, opts.URL,
+		func(id uint64, r *Repo) bool {
+			if r.Labels[PoolLabel] != "" && (opts.Ref == "" || r.Labels[PoolRefLabel] != opts.Ref) {
+				return false
+			}
+			return idle(id)
+		})
+	if id != 0 {
+		return m.reserveLocked(id, opts.Holder, ttl), false
+	}
+	return nil, false
+}
+
+// activate make reserved lease ready after repo is prepared, ErrLeaseNotFound if dropped meanwhile
+func (m *leaseManager) activate(l *leaseContext, ttl time.Duration) (*Lease, error) {
+	r := GetRepo(l.v.RepoID)
+	now := time.Now()
+	m.mu.Lock()
+	if cur, ok := m.byID[l.v.ID]; !ok || cur != l || r == nil {
+		m.mu.Unlock()
+		return nil, ErrLeaseNotFound
+	}
+	l.ready = true
+	l.v.Commit = r.Commit
+	l.v.RenewedAt = now
+	l.v.ExpiresAt = now.Add(ttl)
+	v := l.v
+	m.mu.Unlock()
+	log.Printf("repo %d is leased by %s with lease %s\n", v.RepoID, v.Holder, v.ID)
+	publish(EventLeaseAcquired, v.RepoID, v)
+	return &v, nil
+}
+
+// cancel drop reserved lease which failed to prepare the repo
+func (m *leaseManager) cancel(l *leaseContext) {
+	m.mu.Lock()
+	m.removeLocked(l)
+	m.mu.Unlock()
+}
+
+// releaseSlotLocked release the slot of url taken before the new repo is indexed, requires mu locked
+func (m *leaseManager) releaseSlotLocked(urlKey string) {
+	if m.cloning[urlKey]--; m.cloning[urlKey] <= 0 {
+		delete(m.cloning, urlKey)
+	}
+}
+
+// clone clone a new repo of url for a reserved lease, which takes a slot of url
+func (m *leaseManager) clone(opts LeaseOptions, ttl time.Duration, urlKey string) (*leaseContext, error) {
+	var l *leaseContext
+	id, job, err := createRepoWithHook(opts.Type, opts.URL, opts.Revision, opts.Auth, opts.Labels, opts.Priority, true,
+		func(id uint64) {
+			// reserve the new repo at once, so that it wouldn't be leased by others after cloned,
+			// and the slot is taken by the indexed repo since then
+			m.mu.Lock()
+			l = m.reserveLocked(id, opts.Holder, ttl)
+			m.releaseSlotLocked(urlKey)
+			m.mu.Unlock()
+		})
+	if l == nil {
+		m.mu.Lock()
+		m.releaseSlotLocked(urlKey)
+		m.mu.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if id == 0 {
+		if l != nil {
+			m.cancel(l)
+		}
+		if job != nil && job.Error != "" {
+			return nil, errors.New(fmt.Sprintf("clone repo failed! %s", job.Error))
+		}
+		return nil, errors.New("clone repo failed")
+	}
+	return l, nil
+}
+
+// AcquireLease lease an idle repo of url at hash or ref exclusively. If none is idle, another idle repo of url
+// is checked out to the revision, or a new one is cloned if the repos of url don't reach the max count.
+// The lease expires after ttl unless renewed by heartbeat, ErrNoIdleRepo if no repo could be leased
+func AcquireLease(opts LeaseOptions) (*AcquiredLease, error) {
+	if getBackend(opts.Type) == nil {
+		return nil, errors.New(fmt.Sprintf("cannot lease repo of unknown type %s", opts.Type))
+	}
+	if err := validateURL(opts.URL); err != nil {
+		return nil, err
+	}
+	if opts.Hash == "" && opts.Ref == "" {
+		return nil, errors.New("either hash or ref is required to lease repo")
+	}
+	c := cfg.Global()
+	ttl := c.GetLeaseTTL(opts.TTL)
+	urlKey := getURLKey(opts.Type, opts.URL)
+	leases.reclaim()
+	leases.mu.Lock()
+	l, isAt := leases.reserveIdleLocked(opts, ttl)
+	if l == nil {
+		if len(index.findByURL(opts.Type, opts.URL))+leases.cloning[urlKey] >= c.MaxReposPerURL {
+			leases.mu.Unlock()
+			return nil, ErrNoIdleRepo
+		}
+		leases.cloning[urlKey]++
+	}
+	leases.mu.Unlock()
+	var err error
+	if l == nil {
+		log.Printf("clone %s repo from %s for lease of %s...\n", opts.Type, opts.URL, opts.Holder)
+		l, err = leases.clone(opts, ttl, urlKey)
+	} else if !isAt || opts.Clean {
+		log.Printf("checkout repo %d to revision %+v for lease of %s...\n", l.v.RepoID, opts.Revision, opts.Holder)
+		_, err = updateRepo(l.v.RepoID, opts.Type, opts.Revision, opts.Auth, opts.Priority, true, l.v.ID)
+		if err != nil {
+			leases.cancel(l)
+		}
+	}
+	if err != nil {
+		log.Printf("failed to lease %s repo of %s for %s! %s\n", opts.Type, opts.URL, opts.Holder, err.Error())
+		return nil, err
+	}
+	v, err := leases.activate(l, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &AcquiredLease{ID: v.ID, Lease: *v}, nil
+}
+
+// RenewLease extend the lease by ttl seconds since now, the ttl of lease is kept if not positive
+func RenewLease(id string, ttl int64) (*Lease, error) {
+	leases.reclaim()
+	leases.mu.Lock()
+	defer leases.mu.Unlock()
+	l, ok := leases.byID[id]
+	if !ok || !l.ready {
+		return nil, ErrLeaseNotFound
+	}
+	if ttl <= 0 {
+		ttl = l.v.TTL
+	}
+	now := time.Now()
+	l.v.TTL = int64(cfg.Global().GetLeaseTTL(ttl) / time.Second)
+	l.v.RenewedAt = now
+	l.v.ExpiresAt = now.Add(time.Duration(l.v.TTL) * time.Second)
+	v := l.v
+	return &v, nil
+}
+
+// ReleaseLease release the lease so that the repo could be leased by others
+func ReleaseLease(id string) error {
+	leases.reclaim()
+	leases.mu.Lock()
+	l, ok := leases.byID[id]
+	if !ok || !l.ready {
+		leases.mu.Unlock()
+		return ErrLeaseNotFound
+	}
+	leases.removeLocked(l)
+	leases.mu.Unlock()
+	log.Printf("released lease %s of repo %d held by %s\n", l.v.ID, l.v.RepoID, l.v.Holder)
+	publish(EventLeaseReleased, l.v.RepoID, l.v)
+	return nil
+}
+
+// GetLease get snapshot of lease by id, nil if not found or expired
+func GetLease(id string) *Lease {
+	leases.mu.Lock()
+	defer leases.mu.Unlock()
+	l, ok := leases.byID[id]
+	if !ok || !l.ready || l.expired(time.Now()) {
+		return nil
+	}
+	v := l.v
+	return &v
+}
+
+// ListLeases list snapshots of leases, sorted by repo id
+func ListLeases() []Lease {
+	list := make([]Lease, 0)
+	now := time.Now()
+	leases.mu.Lock()
+	for _, l := range leases.byID {
+		if l.ready && !l.expired(now) {
+			list = append(list, l.v)
+		}
+	}
+	leases.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].RepoID < list[j].RepoID
+	})
+	return list
+}
+
+// StartLeaseReclaimer reclaim expired leases periodically in background
+func StartLeaseReclaimer() {
+	go func() {
+		ticker := time.NewTicker(leaseReclaimInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			leases.reclaim()
+		}
+	}()
+}
@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"encoding/json"
+	"github.com/utmhikari/repomaster/internal/models"
+	"strings"
+	"testing"
+)
+
+// assertNoLeaseID assert lease id not exposed in json of v
+func assertNoLeaseID(t *testing.T, name string, id string, v interface{}) {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), id) {
+		t.Fatalf("expect lease id not exposed in %s, got %s", name, string(b))
+	}
+}
+
+// acquireGitLease lease repo of fixture at hash, released and deleted after test
+func acquireGitLease(t *testing.T, f *gitFixture, hash string) *AcquiredLease {
+	t.Helper()
+	l, err := AcquireLease(LeaseOptions{
+		Type:     TypeGit,
+		URL:      f.root,
+		Hash:     hash,
+		Revision: models.GitRevision{Hash: hash},
+		Holder:   "tester",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ReleaseLease(l.ID)
+		_ = DeleteRepo(l.RepoID)
+	})
+	return l
+}
+
+func TestLeaseIDOnlyInAcquiredLease(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	events, unsubscribe := Subscribe(0)
+	defer unsubscribe()
+
+	l := acquireGitLease(t, f, f.commits[0].String())
+	if l.ID == "" || l.Commit.Hash != f.commits[0].String() {
+		t.Fatalf("unexpected acquired lease: %+v", l)
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"id":"`+l.ID+`"`) {
+		t.Fatalf("expect lease id in acquired lease, got %s", string(b))
+	}
+
+	assertNoLeaseID(t, "listed leases", l.ID, ListLeases())
+	assertNoLeaseID(t, "lease got by id", l.ID, GetLease(l.ID))
+	renewed, err := RenewLease(l.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoLeaseID(t, "renewed lease", l.ID, renewed)
+	if err := ReleaseLease(l.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var leaseEvents int
+	for leaseEvents < 2 {
+		e := <-events
+		if e.Type == EventLeaseAcquired || e.Type == EventLeaseReleased {
+			assertNoLeaseID(t, "event "+string(e.Type), l.ID, e)
+			leaseEvents++
+		}
+	}
+}
+
+func TestLeaseSkipsPoolMembers(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	labels := map[string]string{PoolLabel: "pool", PoolRefLabel: "refs/heads/master"}
+	poolID, _, err := CreateRepo(TypeGit, f.root, models.GitRevision{Branch: "master"}, nil, labels, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if poolID == 0 {
+		t.Fatal("cannot create pool member")
+	}
+	defer func() {
+		_ = DeleteRepo(poolID)
+	}()
+
+	// the idle pool member is not checked out to another hash, a new repo is cloned instead
+	l := acquireGitLease(t, f, f.commits[0].String())
+	if l.RepoID == poolID {
+		t.Fatalf("expect pool member %d not leased at another hash", poolID)
+	}
+	if r := GetRepo(poolID); r == nil || r.Commit.Hash != f.commits[2].String() {
+		t.Fatalf("expect pool member kept at its ref, got %+v", r)
+	}
+}
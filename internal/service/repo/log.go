@@ -0,0 +1,59 @@
+package repo
+
+// LogPage a page of commits of repo
+type LogPage struct {
+	Commits []Commit `json:"commits"`
+	Offset  int      `json:"offset"`
+	Limit   int      `json:"limit"`
+	HasMore bool     `json:"hasMore"`
+}
+
+// LogRepo list a page of commits of repo, the page starts at skip of options and its size is limit of options
+func LogRepo(id uint64, options LogOptions) (*LogPage, error) {
+	ctx := getContext(id)
+	if ctx == nil {
+		return nil, ErrRepoNotFound
+	}
+	b, err := ctx.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	page := &LogPage{Offset: options.Skip, Limit: options.Limit}
+	if options.Limit > 0 {
+		// one more commit to check if there are more pages
+		options.Limit++
+	}
+	commits, err := b.Log(ctx.root, options)
+	if err != nil {
+		return nil, err
+	}
+	if page.Limit > 0 && len(commits) > page.Limit {
+		commits = commits[:page.Limit]
+		page.HasMore = true
+	}
+	page.Commits = commits
+	if page.Commits == nil {
+		page.Commits = make([]Commit, 0)
+	}
+	return page, nil
+}
+
+// BlameRepo get the last commit changed each line of file at revision of repo
+func BlameRepo(id uint64, revision Revision, filePath string) ([]BlameLine, error) {
+	ctx := getContext(id)
+	if ctx == nil {
+		return nil, ErrRepoNotFound
+	}
+	relPath, err := cleanRelPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if relPath == "." {
+		return nil, ErrIsDirectory
+	}
+	b, err := ctx.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	return b.Blame(ctx.root, revision, relPath)
+}
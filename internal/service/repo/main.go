@@ -1,15 +1,19 @@
 package repo
 
 import (
+	"errors"
+	"fmt"
 	"github.com/utmhikari/repomaster/internal/service/cfg"
 	"github.com/utmhikari/repomaster/pkg/util"
 	"io/ioutil"
 	"log"
 	"math"
+	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 )
 
 // cache stores the repo contexts
@@ -30,7 +34,11 @@ func GetCacheSnapshot() []CacheItem {
 		if !idOk || !ctxOk {
 			return true
 		}
+		ctx.mu.RLock()
 		repoCopy := ctx.v
+		ctx.mu.RUnlock()
+		repoCopy.QueuePosition = workers.repoPosition(id)
+		repoCopy.Leased = leases.isLeased(id)
 		cacheSnapshot = append(cacheSnapshot, CacheItem{
 			ID:   id,
 			Repo: repoCopy,
@@ -46,7 +54,7 @@ func GetCacheSnapshot() []CacheItem {
 // getContext get context by id
 func getContext(id uint64) *context {
 	ctxInterface, ok := cache.Load(id)
-	if !ok{
+	if !ok {
 		return nil
 	}
 	ctx, ctxOk := ctxInterface.(*context)
@@ -68,47 +76,90 @@ func GetRepo(id uint64) *Repo {
 		return nil
 	}
 	ctx.mu.RLock()
-	defer ctx.mu.RUnlock()
-	return &ctx.v
+	repoCopy := ctx.v
+	ctx.mu.RUnlock()
+	repoCopy.QueuePosition = workers.repoPosition(id)
+	repoCopy.Leased = leases.isLeased(id)
+	return &repoCopy
 }
 
-// FindRepoByHash get info of repo by specific url and hash
-func FindRepoByHash(t Type, url string, hash string) (uint64, *Repo) {
-	var repoID uint64 = 0
-	var repoInst *Repo = nil
-	cache.Range(func(k, v interface{}) bool {
-		id, idOk := k.(uint64)
-		ctx, ctxOk := v.(context)
-		if !idOk || !ctxOk {
-			return true
+// findActiveRepo find the first active repo of candidate ids matching type, url and condition
+func findActiveRepo(ids []uint64, t Type, url string, match func(id uint64, r *Repo) bool) (uint64, *Repo) {
+	urlKey := getURLKey(t, url)
+	for _, id := range ids {
+		ctx := getContext(id)
+		if ctx == nil {
+			continue
 		}
+		// the index may be stale, so check repo again with lock
 		ctx.mu.RLock()
-		defer ctx.mu.RUnlock()
 		repoCopy := ctx.v
+		ctx.mu.RUnlock()
 		if repoCopy.Status == StatusActive &&
 			repoCopy.Type == t &&
-			repoCopy.URL == url &&
-			repoCopy.Commit.Hash == hash {
-			repoID = id
-			repoInst = &repoCopy
-			return false
+			getURLKey(repoCopy.Type, repoCopy.URL) == urlKey &&
+			match(id, &repoCopy) {
+			return id, &repoCopy
 		}
-		return true
+	}
+	return 0, nil
+}
+
+// FindRepoByHash get info of active repo by specific url and hash, git hash could be abbreviated,
+// equivalent urls are matched, e.g. ssh and https ones
+func FindRepoByHash(t Type, url string, hash string) (uint64, *Repo) {
+	return findActiveRepo(index.findByHash(t, url, hash), t, url, func(_ uint64, r *Repo) bool {
+		return isRepoAtHash(r, hash)
 	})
-	return repoID, repoInst
+}
+
+// FindRepoByRef get info of active repo by specific url and the ref checked out,
+// e.g. refs/heads/main for git and ^/trunk for svn
+func FindRepoByRef(t Type, url string, ref string) (uint64, *Repo) {
+	return findActiveRepo(index.findByRef(t, url, ref), t, url, func(_ uint64, r *Repo) bool {
+		return r.Commit.Ref == ref
+	})
+}
+
+// isRepoAtHash is head of repo at hash, git hash could be abbreviated
+func isRepoAtHash(r *Repo, hash string) bool {
+	return r.Commit.Hash == hash || r.Type == TypeGit && isGitHashMatched(r.Commit.Hash, hash)
+}
+
+// FindReposByURL get snapshots of repos of type and url in any status, equivalent urls are matched
+func FindReposByURL(t Type, url string) []CacheItem {
+	items := make([]CacheItem, 0)
+	urlKey := getURLKey(t, url)
+	for _, id := range index.findByURL(t, url) {
+		r := GetRepo(id)
+		if r != nil && r.Type == t && getURLKey(r.Type, r.URL) == urlKey {
+			items = append(items, CacheItem{ID: id, Repo: *r})
+		}
+	}
+	return items
 }
 
 // createContext create a new context by id
 func createContext(id uint64, t Type, s Status) {
+	now := time.Now()
+	createContextFromRepo(id, Repo{
+		Type:      t,
+		Status:    s,
+		Commit:    Commit{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+}
+
+// createContextFromRepo create a new context by id with existed repo info
+func createContextFromRepo(id uint64, r Repo) {
 	cache.Store(id, &context{
+		id:   id,
 		root: getRepoRoot(id),
 		mu:   sync.RWMutex{},
-		v: Repo{
-			Type:   t,
-			Status: s,
-			Commit: Commit{},
-		},
+		v:    r,
 	})
+	index.put(id, r)
 }
 
 // createDefaultContext create default repo context, with everything unknown
@@ -119,11 +170,24 @@ func createDefaultContext(id uint64) {
 // requestNewContextWithID request a new context instance, with its ID
 func requestNewContextWithID(t Type, s Status) (*context, uint64) {
 	var i uint64 = 1
-	for ; i <= math.MaxUint64; i++ {
-		_, ok := cache.Load(i)
-		if !ok {
-			createContext(i, t, s)
-			return getContext(i), i
+	for ; i < math.MaxUint64; i++ {
+		if _, ok := cache.Load(i); ok {
+			continue
+		}
+		// the id may be taken concurrently, so store only if absent
+		now := time.Now()
+		ctx := &context{
+			id:   i,
+			root: getRepoRoot(i),
+			v: Repo{
+				Type:      t,
+				Status:    s,
+				CreatedAt: now,
+				UpdatedAt: now,
+			},
+		}
+		if _, loaded := cache.LoadOrStore(i, ctx); !loaded {
+			return ctx, i
 		}
 	}
 	return nil, 0
@@ -137,19 +201,28 @@ func refreshContextByID(id uint64) {
 		return
 	}
 	// ignore updating contexts
-	if ctx.v.Status == StatusUpdating {
+	if _, ok := ctx.tryAcquire(acceptAnyStatus); !ok {
 		return
 	}
-	if _, err := ctx.getGitRepo(); err == nil {
-		ctx.refreshGitRepo()
+	if b := detectBackend(ctx.root); b != nil {
+		ctx.refreshRepo(b)
 	} else {
 		ctx.SetRepoStatusError("unrecognized repo")
 	}
 }
 
+// loadStoreOnce load metadata store only at the first refresh
+var loadStoreOnce sync.Once
+
+// refreshing the refreshes of repos in background started by Refresh
+var refreshing sync.WaitGroup
+
 // deleteContext delete a context
 func deleteContext(id uint64) {
 	cache.Delete(id)
+	index.remove(id)
+	leases.removeRepo(id)
+	publish(EventRepoDeleted, id, nil)
 }
 
 // Refresh refresh the repo cache
@@ -161,6 +234,11 @@ func Refresh() {
 	if filesErr != nil {
 		panic(filesErr)
 	}
+	// load metadata of repos recorded before
+	var storedRepos map[uint64]Repo
+	loadStoreOnce.Do(func() {
+		storedRepos = loadStore()
+	})
 	// initialize contexts
 	existedIDs := make(map[uint64]bool)
 	for _, file := range files {
@@ -170,8 +248,17 @@ func Refresh() {
 			repoRootDir := filepath.Join(repoRoot, filename)
 			if util.IsDirectory(repoRootDir) {
 				if _, ok := cache.Load(id); !ok {
-					createDefaultContext(id)
-					log.Printf("created repo context %d\n", id)
+					if storedRepo, isStored := storedRepos[id]; isStored {
+						// the repo was interrupted while updating, let it be refreshed
+						if storedRepo.Status == StatusUpdating {
+							storedRepo.Status = StatusUnknown
+						}
+						createContextFromRepo(id, storedRepo)
+						log.Printf("restored repo context %d\n", id)
+					} else {
+						createDefaultContext(id)
+						log.Printf("created repo context %d\n", id)
+					}
 				}
 				existedIDs[id] = true
 			}
@@ -180,25 +267,199 @@ func Refresh() {
 	// refresh contexts
 	var idsToRefresh []uint64
 	var idsToDelete []uint64
+	var reposToDelete []Repo
 	cache.Range(func(k, v interface{}) bool {
 		id, idOk := k.(uint64)
 		ctx, ctxOk := v.(*context)
 		if !idOk || !ctxOk {
 			return true
 		}
+		ctx.mu.RLock()
+		status := ctx.v.Status
+		ctx.mu.RUnlock()
 		if _, ok := existedIDs[id]; ok {
 			log.Printf("context %d will be refreshed...\n", id)
 			idsToRefresh = append(idsToRefresh, id)
-		} else if !(ctx.v.Status == StatusUpdating) {
+		} else if !(status == StatusUpdating) {
 			log.Printf("context %d will be deleted as repo is empty...\n", id)
 			idsToDelete = append(idsToDelete, id)
+			ctx.mu.RLock()
+			reposToDelete = append(reposToDelete, ctx.v)
+			ctx.mu.RUnlock()
 		}
 		return true
 	})
+	for id := range storedRepos {
+		if _, ok := existedIDs[id]; !ok {
+			log.Printf("metadata of repo %d will be dropped as repo is empty...\n", id)
+			reposToDelete = append(reposToDelete, storedRepos[id])
+		}
+	}
 	for _, id := range idsToDelete {
-		go deleteContext(id)
+		deleteContext(id)
+	}
+	saveStore()
+	// mirrors shared by dead repos only are removed as well
+	for _, r := range reposToDelete {
+		removeGitMirrorOfURLIfUnused(r.Type, r.URL)
 	}
 	for _, id := range idsToRefresh {
-		go refreshContextByID(id)
+		refreshing.Add(1)
+		go func(id uint64) {
+			defer refreshing.Done()
+			refreshContextByID(id)
+		}(id)
+	}
+}
+
+// CreateRepo create a new repo of type from url in worker pool, returns the context id and the clone job,
+// the context id is 0 if failed to create synchronously
+func CreateRepo(t Type, url string, revision Revision, auth Auth,
+	labels map[string]string, priority int, isSync bool) (uint64, *Job, error) {
+	return createRepoWithHook(t, url, revision, auth, labels, priority, isSync, nil)
+}
+
+// createRepoWithHook create a new repo like CreateRepo, onCreated is called with the context id
+// before the clone job is queued if not nil
+func createRepoWithHook(t Type, url string, revision Revision, auth Auth,
+	labels map[string]string, priority int, isSync bool, onCreated func(id uint64)) (uint64, *Job, error) {
+	b := getBackend(t)
+	if b == nil {
+		return 0, nil, errors.New(fmt.Sprintf("cannot create repo of unknown type %s", t))
+	}
+	if err := validateURL(url); err != nil {
+		return 0, nil, err
+	}
+	if err := validateRevision(t, revision); err != nil {
+		return 0, nil, err
+	}
+	log.Printf("clone %s repo from %s at revision %+v...\n", t, url, revision)
+	// request new context with updating status, so that the context wouldn't be gced
+	ctx, id := requestNewContextWithID(t, StatusUpdating)
+	ctx.mu.Lock()
+	ctx.v.URL = url
+	ctx.v.Labels = labels
+	repoCopy := ctx.v
+	ctx.mu.Unlock()
+	// index the url at once, so that queued repos take slots of url
+	index.put(id, repoCopy)
+	publish(EventRepoCreated, id, repoCopy)
+	if onCreated != nil {
+		onCreated(id)
+	}
+	job := createJob(id, JobTypeClone, revision, cfg.Global().GetCloneTimeout(), priority)
+	err := runQueued(job, t, url, priority, isSync, func() {
+		_ = ctx.createRepo(b, url, revision, auth, job)
+	})
+	if err != nil {
+		removeJob(job.v.ID)
+		deleteContext(id)
+		return 0, nil, err
+	}
+	v := job.snapshotWithPosition()
+	if isSync && v.Status != JobStatusSucceeded {
+		// create failed
+		return 0, &v, nil
+	}
+	return id, &v, nil
+}
+
+// UpdateRepo update an existed repo of type to revision in worker pool, returns the checkout job,
+// leased repos couldn't be updated
+func UpdateRepo(id uint64, t Type, revision Revision, auth Auth, priority int, isSync bool) (*Job, error) {
+	if leases.isLeased(id) {
+		return nil, ErrRepoLeased
+	}
+	return updateRepo(id, t, revision, auth, priority, isSync, "")
+}
+
+// updateRepo update an existed repo of type to revision in worker pool on behalf of lease,
+// the repo must not be leased if lease id is empty, or be leased by it otherwise
+func updateRepo(id uint64, t Type, revision Revision, auth Auth, priority int, isSync bool,
+	leaseID string) (*Job, error) {
+	ctx := getContext(id)
+	if ctx == nil {
+		return nil, errors.New(fmt.Sprintf("cannot get repo with ID %d", id))
+	}
+	ctx.mu.RLock()
+	repoType, url := ctx.v.Type, ctx.v.URL
+	ctx.mu.RUnlock()
+	if repoType != t {
+		return nil, errors.New(fmt.Sprintf("repo %d is a %s repo rather than %s", id, repoType, t))
+	}
+	if err := validateRevision(t, revision); err != nil {
+		return nil, err
+	}
+	job := createJob(id, JobTypeCheckout, revision, cfg.Global().GetCheckoutTimeout(), priority)
+	var checkoutErr error
+	err := runQueued(job, repoType, url, priority, isSync, func() {
+		// the repo may be leased while the job is pending, which is checked again by checkoutRepo
+		checkoutErr = ctx.checkoutRepo(revision, auth, true, leaseID, job)
+	})
+	if err != nil {
+		removeJob(job.v.ID)
+		return nil, err
+	}
+	if isSync && checkoutErr != nil {
+		return nil, errors.New(fmt.Sprintf("checkout %s repo failed! %s", t, checkoutErr.Error()))
+	}
+	v := job.snapshotWithPosition()
+	return &v, nil
+}
+
+// DeleteRepo delete repo by id and remove its working copy, leased repos couldn't be deleted
+func DeleteRepo(id uint64) error {
+	ctx := getContext(id)
+	if ctx == nil {
+		return ErrRepoNotFound
+	}
+	// keep repo updating while removing, so that it wouldn't be operated
+	prevStatus, err := leases.tryAcquireRepo(ctx, "", acceptAnyStatus)
+	if err != nil {
+		return err
+	}
+	ctx.mu.RLock()
+	repoCopy := ctx.v
+	ctx.mu.RUnlock()
+	repoCopy.Status = prevStatus
+	log.Printf("delete repo %d at %s...\n", id, ctx.root)
+	if ctx.root != getRepoRoot(id) {
+		ctx.SetRepoStatusError("unexpected repo root " + ctx.root)
+		return errors.New(fmt.Sprintf("refuse to remove unexpected repo root %s", ctx.root))
+	}
+	mirrorDir := getGitMirrorOf(ctx.root)
+	if err := os.RemoveAll(ctx.root); err != nil {
+		log.Printf("failed to remove repo %d at %s! %s\n", id, ctx.root, err.Error())
+		ctx.SetRepoStatusError(err.Error())
+		return err
+	}
+	deleteContext(id)
+	removeJobsOfRepo(id)
+	recordDeletion(id, repoCopy)
+	if mirrorDir != "" {
+		removeGitMirrorIfUnused(mirrorDir)
+	}
+	log.Printf("successfully deleted repo %d\n", id)
+	return nil
+}
+
+// DeleteRepos delete repos matching url and status, empty values match all,
+// returns the deleted ids and error messages of failed ones
+func DeleteRepos(url string, status Status) ([]uint64, map[uint64]string) {
+	deleted := make([]uint64, 0)
+	failed := make(map[uint64]string)
+	for _, item := range GetCacheSnapshot() {
+		if url != "" && NormalizeURL(item.Repo.Type, item.Repo.URL) != NormalizeURL(item.Repo.Type, url) {
+			continue
+		}
+		if status != "" && item.Repo.Status != status {
+			continue
+		}
+		if err := DeleteRepo(item.ID); err != nil {
+			failed[item.ID] = err.Error()
+		} else {
+			deleted = append(deleted, item.ID)
+		}
 	}
+	return deleted, failed
 }
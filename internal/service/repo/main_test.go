@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"github.com/utmhikari/repomaster/pkg/util"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain run tests with a global config whose repo root is a temp dir
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "repomaster-test-")
+	if err != nil {
+		log.Fatalf("cannot create temp dir! %s\n", err.Error())
+	}
+	cfgPath := filepath.Join(dir, "repomaster.json")
+	// fixtures are local repos
+	c := cfg.Config{Port: 18080, RepoRoot: filepath.Join(dir, "repos"), AllowLocalURL: true}
+	if err := util.WriteJsonFile(cfgPath, &c); err != nil {
+		log.Fatalf("cannot write config! %s\n", err.Error())
+	}
+	if err := cfg.InitGlobalConfig(cfgPath); err != nil {
+		log.Fatalf("cannot init config! %s\n", err.Error())
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTempDir create a temp dir removed after test
+func newTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "repomaster-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// nopProgress progress discarding everything
+type nopProgress struct{}
+
+func (nopProgress) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (nopProgress) SetPhase(phase JobPhase) {}
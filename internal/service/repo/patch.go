@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"bytes"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"strings"
+)
+
+// binarySniffLen length of content to sniff binary data, the same as git
+const binarySniffLen = 8000
+
+// patchFile one side of a file patch, implements fdiff.File
+type patchFile struct {
+	path    string
+	hash    plumbing.Hash
+	mode    filemode.FileMode
+	content []byte
+}
+
+func (f *patchFile) Hash() plumbing.Hash     { return f.hash }
+func (f *patchFile) Mode() filemode.FileMode { return f.mode }
+func (f *patchFile) Path() string            { return f.path }
+
+// patchChunk implements fdiff.Chunk
+type patchChunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c *patchChunk) Content() string       { return c.content }
+func (c *patchChunk) Type() fdiff.Operation { return c.op }
+
+// filePatch implements fdiff.FilePatch and fdiff.Patch of a single file
+type filePatch struct {
+	from     fdiff.File
+	to       fdiff.File
+	chunks   []fdiff.Chunk
+	isBinary bool
+}
+
+func (p *filePatch) IsBinary() bool                  { return p.isBinary }
+func (p *filePatch) Files() (fdiff.File, fdiff.File) { return p.from, p.to }
+func (p *filePatch) Chunks() []fdiff.Chunk           { return p.chunks }
+func (p *filePatch) FilePatches() []fdiff.FilePatch  { return []fdiff.FilePatch{p} }
+func (p *filePatch) Message() string                 { return "" }
+
+// isBinaryContent does content look like binary data
+func isBinaryContent(content []byte) bool {
+	if len(content) > binarySniffLen {
+		content = content[:binarySniffLen]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// encodeUnifiedPatch encode unified patch of a file, from or to is nil if file is added or deleted
+func encodeUnifiedPatch(from *patchFile, to *patchFile) (string, error) {
+	p := &filePatch{}
+	var fromContent, toContent []byte
+	if from != nil {
+		p.from = from
+		fromContent = from.content
+	}
+	if to != nil {
+		p.to = to
+		toContent = to.content
+	}
+	p.isBinary = isBinaryContent(fromContent) || isBinaryContent(toContent)
+	if !p.isBinary {
+		for _, d := range diff.Do(string(fromContent), string(toContent)) {
+			chunk := &patchChunk{content: d.Text}
+			switch d.Type {
+			case diffmatchpatch.DiffInsert:
+				chunk.op = fdiff.Add
+			case diffmatchpatch.DiffDelete:
+				chunk.op = fdiff.Delete
+			default:
+				chunk.op = fdiff.Equal
+			}
+			p.chunks = append(p.chunks, chunk)
+		}
+	}
+	var sb strings.Builder
+	if err := fdiff.NewUnifiedEncoder(&sb, fdiff.DefaultContextLines).Encode(p); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,149 @@
+package repo
+
+import (
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"log"
+	"sort"
+	"sync"
+)
+
+// task a queued job with the function to run it
+type task struct {
+	job *jobContext
+	// urlKey is the index key of type and remote url of repo, to limit concurrent jobs of equivalent urls
+	urlKey string
+	// priority is the priority of task, higher ones run first
+	priority int
+	// run runs the job, exits at once if the job is canceled
+	run func()
+	// done is closed after run
+	done chan struct{}
+}
+
+// pool the worker pool running queued tasks
+type pool struct {
+	mu sync.Mutex
+	// queue pending tasks, sorted by priority desc and then by job id asc
+	queue []*task
+	// running count of running tasks
+	running int
+	// runningByURL count of running tasks by url key
+	runningByURL map[string]int
+	// runningRepos repos with running task, jobs of the same repo run one by one
+	runningRepos map[uint64]bool
+}
+
+// workers the global worker pool of repo jobs
+var workers = &pool{
+	runningByURL: make(map[string]int),
+	runningRepos: make(map[uint64]bool),
+}
+
+// submit enqueue task and dispatch, ErrQueueFull if the queue is full
+func (p *pool) submit(t *task) error {
+	c := cfg.Global()
+	p.mu.Lock()
+	if len(p.queue) >= c.MaxQueueSize {
+		p.mu.Unlock()
+		return ErrQueueFull
+	}
+	t.done = make(chan struct{})
+	i := sort.Search(len(p.queue), func(i int) bool {
+		return p.queue[i].priority < t.priority
+	})
+	p.queue = append(p.queue, nil)
+	copy(p.queue[i+1:], p.queue[i:])
+	p.queue[i] = t
+	p.mu.Unlock()
+	p.dispatch()
+	return nil
+}
+
+// dispatch run queued tasks in order while workers are available,
+// tasks of busy repos or urls reaching the concurrency limit are skipped
+func (p *pool) dispatch() {
+	c := cfg.Global()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 0; i < len(p.queue) && p.running < c.MaxWorkers; {
+		t := p.queue[i]
+		if p.runningRepos[t.job.v.RepoID] || p.runningByURL[t.urlKey] >= c.MaxJobsPerURL {
+			i++
+			continue
+		}
+		p.queue = append(p.queue[:i], p.queue[i+1:]...)
+		p.running++
+		p.runningByURL[t.urlKey]++
+		p.runningRepos[t.job.v.RepoID] = true
+		go p.runTask(t)
+	}
+}
+
+// runTask run task and dispatch next ones after it finished
+func (p *pool) runTask(t *task) {
+	defer func() {
+		p.mu.Lock()
+		p.running--
+		if p.runningByURL[t.urlKey]--; p.runningByURL[t.urlKey] <= 0 {
+			delete(p.runningByURL, t.urlKey)
+		}
+		delete(p.runningRepos, t.job.v.RepoID)
+		p.mu.Unlock()
+		p.dispatch()
+	}()
+	defer close(t.done)
+	t.run()
+}
+
+// remove remove pending task of job from queue, nil if not queued
+func (p *pool) remove(jobID uint64) *task {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, t := range p.queue {
+		if t.job.v.ID == jobID {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return t
+		}
+	}
+	return nil
+}
+
+// position get 1-based position of job in queue, 0 if not queued
+func (p *pool) position(jobID uint64) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, t := range p.queue {
+		if t.job.v.ID == jobID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// repoPosition get 1-based position of the first queued job of repo, 0 if not queued
+func (p *pool) repoPosition(repoID uint64) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, t := range p.queue {
+		if t.job.v.RepoID == repoID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// runQueued submit job of repo of type and url to worker pool, and wait for it if sync
+func runQueued(job *jobContext, repoType Type, url string, priority int, isSync bool, run func()) error {
+	t := &task{job: job, urlKey: getURLKey(repoType, url), priority: priority, run: run}
+	if err := workers.submit(t); err != nil {
+		log.Printf("cannot queue job %d of repo %d! %s\n", job.v.ID, job.v.RepoID, err.Error())
+		return err
+	}
+	if pos := workers.position(job.v.ID); pos > 0 {
+		log.Printf("queued job %d of repo %d at position %d\n", job.v.ID, job.v.RepoID, pos)
+	}
+	if isSync {
+		<-t.done
+	}
+	return nil
+}
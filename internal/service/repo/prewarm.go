@@ -0,0 +1,242 @@
+package repo
+
+import (
+	stdcontext "context"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// PoolLabel label of the name of pre-warmed pool that repo belongs to
+	PoolLabel = "repomaster.pool"
+	// PoolRefLabel label of the ref that repo of pre-warmed pool is kept at
+	PoolRefLabel = "repomaster.poolRef"
+)
+
+// PoolStatus status of repos kept at a ref of pre-warmed pool
+type PoolStatus struct {
+	Name string `json:"name"`
+	Type Type   `json:"type"`
+	URL  string `json:"url"`
+	Ref  string `json:"ref"`
+	// Count is the count of repos to keep
+	Count int `json:"count"`
+	// Repos is the ids of repos in pool at ref
+	Repos []uint64 `json:"repos"`
+	// Active is the count of active repos
+	Active int `json:"active"`
+}
+
+// normalizePoolRef get the ref checked out by repos of pool, e.g. main is refs/heads/main for git,
+// and trunk is ^/trunk for svn
+func normalizePoolRef(t Type, ref string) string {
+	if t == TypeSvn {
+		return "^/" + strings.TrimPrefix(ref, "^/")
+	}
+	if strings.HasPrefix(ref, "refs/") {
+		return ref
+	}
+	return "refs/heads/" + ref
+}
+
+// getPoolRevision get the revision to checkout ref of pool
+func getPoolRevision(t Type, ref string) Revision {
+	if t == TypeSvn {
+		return models.NewSvnRevisionFromRef(ref)
+	}
+	return models.NewGitRevisionFromRef(ref)
+}
+
+// getPoolGitRefs get the full remote ref of git pool ref, and the head ref of repos checked out at it,
+// which is detached unless at a branch
+func getPoolGitRefs(ref string) (string, string) {
+	revision := models.NewGitRevisionFromRef(ref)
+	switch {
+	case revision.Tag != "":
+		return plumbing.NewTagReferenceName(revision.Tag).String(), plumbing.HEAD.String()
+	case revision.Rev != "":
+		return revision.Rev, plumbing.HEAD.String()
+	}
+	branch := plumbing.NewBranchReferenceName(revision.Branch).String()
+	return branch, branch
+}
+
+// getPoolAuth get auth of pool
+func getPoolAuth(p *cfg.PoolConfig) Auth {
+	if Type(p.Type) == TypeSvn {
+		return &models.SvnAuth{Username: p.Username, Password: p.Password}
+	}
+	gitAuth := models.GitAuth{Username: p.Username, Password: p.Password, Key: p.Key}
+	if authMethod := gitAuth.ToAuthMethod(); authMethod != nil {
+		return authMethod
+	}
+	return nil
+}
+
+// getPoolMembers get repos of pool grouped by ref label
+func getPoolMembers(name string) map[string][]CacheItem {
+	members := make(map[string][]CacheItem)
+	for _, item := range GetCacheSnapshot() {
+		if item.Repo.Labels[PoolLabel] == name {
+			ref := item.Repo.Labels[PoolRefLabel]
+			members[ref] = append(members[ref], item)
+		}
+	}
+	return members
+}
+
+// isPoolMemberIdle is repo of pool idle to be deleted or moved
+func isPoolMemberIdle(item CacheItem) bool {
+	return item.Repo.Status == StatusActive && !item.Repo.Leased && item.Repo.QueuePosition == 0
+}
+
+// syncPool clone missing repos, delete redundant ones and keep idle ones at refs of pool,
+// refs removed from config are synced as count 0
+func syncPool(p *cfg.PoolConfig) {
+	t := Type(p.Type)
+	if getBackend(t) == nil {
+		log.Printf("cannot sync pool %s of unknown type %s\n", p.Name, t)
+		return
+	}
+	members := getPoolMembers(p.Name)
+	auth := getPoolAuth(p)
+	for _, r := range p.Refs {
+		ref := normalizePoolRef(t, r.Ref)
+		syncPoolRef(p, t, ref, r.Count, members[ref], auth)
+		delete(members, ref)
+	}
+	for ref, items := range members {
+		syncPoolRef(p, t, ref, 0, items, auth)
+	}
+}
+
+// syncPoolRef keep count of repos of pool at ref
+func syncPoolRef(p *cfg.PoolConfig, t Type, ref string, count int, items []CacheItem, auth Auth) {
+	// broken repos are deleted and cloned again
+	healthy := make([]CacheItem, 0, len(items))
+	for _, item := range items {
+		if item.Repo.Status == StatusError && !item.Repo.Leased {
+			log.Printf("delete repo %d of pool %s in error status! %s\n", item.ID, p.Name, item.Repo.Desc)
+			if err := DeleteRepo(item.ID); err == nil {
+				continue
+			}
+		}
+		healthy = append(healthy, item)
+	}
+	// delete redundant idle repos, newest first
+	for i := len(healthy) - 1; i >= 0 && len(healthy) > count; i-- {
+		if !isPoolMemberIdle(healthy[i]) {
+			continue
+		}
+		log.Printf("delete redundant repo %d of pool %s at %s\n", healthy[i].ID, p.Name, ref)
+		if err := DeleteRepo(healthy[i].ID); err != nil {
+			log.Printf("failed to delete repo %d of pool %s! %s\n", healthy[i].ID, p.Name, err.Error())
+			continue
+		}
+		healthy = append(healthy[:i], healthy[i+1:]...)
+	}
+	revision := getPoolRevision(t, ref)
+	// clone missing repos
+	labels := map[string]string{PoolLabel: p.Name, PoolRefLabel: ref}
+	for i := len(healthy); i < count; i++ {
+		id, _, err := CreateRepo(t, p.URL, revision, auth, labels, p.Priority, false)
+		if err != nil {
+			log.Printf("failed to clone repo of pool %s at %s! %s\n", p.Name, ref, err.Error())
+			break
+		}
+		log.Printf("cloning repo %d of pool %s at %s...\n", id, p.Name, ref)
+	}
+	// checkout idle repos moved away from ref or behind remote
+	var want, wantRef, headRef string
+	if t == TypeGit {
+		wantRef, headRef = getPoolGitRefs(ref)
+		gitAuth, err := toGitAuth(auth)
+		if err != nil {
+			log.Printf("invalid auth of pool %s! %s\n", p.Name, err.Error())
+			return
+		}
+		ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), gitLsRemoteTimeout)
+		refs, err := lsRemoteGit(ctx, p.URL, gitAuth)
+		cancel()
+		if err != nil {
+			log.Printf("cannot sync repos of pool %s at %s as failed to list remote refs! %s\n",
+				p.Name, ref, err.Error())
+			return
+		}
+		for _, list := range [][]GitRef{refs.Branches, refs.Tags} {
+			for _, r := range list {
+				if r.Ref == wantRef {
+					want = r.Hash
+				}
+			}
+		}
+		if want == "" {
+			log.Printf("cannot sync repos of pool %s as ref %s is not found in remote\n", p.Name, ref)
+			return
+		}
+	}
+	for _, item := range healthy {
+		// svn working copies are updated every time, which is incremental
+		if !isPoolMemberIdle(item) ||
+			t == TypeGit && item.Repo.Commit.Hash == want && item.Repo.Commit.Ref == headRef {
+			continue
+		}
+		if _, err := UpdateRepo(item.ID, t, revision, auth, p.Priority, false); err != nil {
+			log.Printf("failed to checkout repo %d of pool %s to %s! %s\n", item.ID, p.Name, ref, err.Error())
+		}
+	}
+}
+
+// syncPools sync all pools in config
+func syncPools() {
+	c := cfg.Global()
+	for i := range c.Pools {
+		syncPool(&c.Pools[i])
+	}
+}
+
+// StartPools sync pre-warmed repo pools in config once the repos are refreshed and then periodically in background,
+// so that restored members are synced by their refreshed status rather than the stored one
+func StartPools() {
+	c := cfg.Global()
+	if len(c.Pools) == 0 {
+		return
+	}
+	log.Printf("start syncing %d repo pools every %d seconds...\n", len(c.Pools), c.PoolSyncInterval)
+	go func() {
+		refreshing.Wait()
+		ticker := time.NewTicker(time.Duration(c.PoolSyncInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			syncPools()
+			<-ticker.C
+		}
+	}()
+}
+
+// ListPools get status of repos at each ref of pools in config
+func ListPools() []PoolStatus {
+	c := cfg.Global()
+	list := make([]PoolStatus, 0)
+	for i := range c.Pools {
+		p := &c.Pools[i]
+		t := Type(p.Type)
+		members := getPoolMembers(p.Name)
+		for _, r := range p.Refs {
+			ref := normalizePoolRef(t, r.Ref)
+			status := PoolStatus{Name: p.Name, Type: t, URL: p.URL, Ref: ref, Count: r.Count, Repos: make([]uint64, 0)}
+			for _, item := range members[ref] {
+				status.Repos = append(status.Repos, item.ID)
+				if item.Repo.Status == StatusActive {
+					status.Active++
+				}
+			}
+			list = append(list, status)
+		}
+	}
+	return list
+}
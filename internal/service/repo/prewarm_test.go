@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"testing"
+	"time"
+)
+
+// waitJobsOfRepo wait until all jobs of repo are done, returns the count of jobs
+func waitJobsOfRepo(t *testing.T, id uint64) int {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		list := ListJobsOfRepo(id)
+		done := true
+		for _, job := range list {
+			done = done && job.Status.IsDone()
+		}
+		if done {
+			return len(list)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs of repo %d are not done in time: %+v", id, list)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGetPoolGitRefs(t *testing.T) {
+	cases := []struct {
+		ref     string
+		remote  string
+		headRef string
+	}{
+		{"main", "refs/heads/main", "refs/heads/main"},
+		{"refs/heads/main", "refs/heads/main", "refs/heads/main"},
+		{"refs/tags/v1.0", "refs/tags/v1.0", "HEAD"},
+		{"refs/pull/1/head", "refs/pull/1/head", "HEAD"},
+	}
+	for _, c := range cases {
+		if remote, headRef := getPoolGitRefs(c.ref); remote != c.remote || headRef != c.headRef {
+			t.Fatalf("expect refs %s and %s of %s, got %s and %s", c.remote, c.headRef, c.ref, remote, headRef)
+		}
+	}
+}
+
+func TestSyncPoolRefChecksOutDetachedMember(t *testing.T) {
+	dir := newTempDir(t)
+	f := newGitFixture(t, dir)
+	p := &cfg.PoolConfig{Name: "pool", URL: f.root}
+	labels := map[string]string{PoolLabel: p.Name, PoolRefLabel: "master"}
+	id, _, err := CreateRepo(TypeGit, f.root, models.GitRevision{Branch: "master"}, nil, labels, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == 0 {
+		t.Fatal("cannot create pool member")
+	}
+	t.Cleanup(func() {
+		_ = DeleteRepo(id)
+	})
+	ctx := getContext(id)
+
+	// the member is at the hash of ref but detached, e.g. left by a lease
+	revision := models.GitRevision{Hash: f.commits[2].String()}
+	if err := ctx.checkoutRepo(revision, nil, true, "", createJob(id, JobTypeCheckout, revision, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	assertGitHead(t, ctx.root, f.commits[2], plumbing.HEAD.String())
+	syncPoolRef(p, TypeGit, "master", 1, getPoolMembers(p.Name)["master"], nil)
+	count := waitJobsOfRepo(t, id)
+	assertGitHead(t, ctx.root, f.commits[2], "refs/heads/master")
+
+	// the member at ref is kept
+	syncPoolRef(p, TypeGit, "master", 1, getPoolMembers(p.Name)["master"], nil)
+	if n := waitJobsOfRepo(t, id); n != count {
+		t.Fatalf("expect no checkout of member at ref, got %d jobs after %d", n, count)
+	}
+}
@@ -0,0 +1,281 @@
+package repo
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitRef a branch or tag with its target commit
+type GitRef struct {
+	// Name is the short name, e.g. main or v1.0.0
+	Name string `json:"name"`
+	// Ref is the full reference name, e.g. refs/heads/main
+	Ref string `json:"ref"`
+	// Hash is the target commit hash, annotated tags are peeled if possible
+	Hash string `json:"hash"`
+	// Time is the commit time of target, nil if unknown
+	Time *time.Time `json:"time,omitempty"`
+}
+
+// GitRefs branches and tags of a git repo
+type GitRefs struct {
+	// Head is the current branch of local repo, or the default branch of remote
+	Head           string   `json:"head"`
+	Branches       []GitRef `json:"branches"`
+	RemoteBranches []GitRef `json:"remoteBranches"`
+	Tags           []GitRef `json:"tags"`
+}
+
+// newGitRefs create empty git refs
+func newGitRefs() *GitRefs {
+	return &GitRefs{
+		Branches:       make([]GitRef, 0),
+		RemoteBranches: make([]GitRef, 0),
+		Tags:           make([]GitRef, 0),
+	}
+}
+
+// sort sort refs by name
+func (g *GitRefs) sort() {
+	for _, refs := range [][]GitRef{g.Branches, g.RemoteBranches, g.Tags} {
+		sort.Slice(refs, func(i, j int) bool {
+			return refs[i].Name < refs[j].Name
+		})
+	}
+}
+
+// add add ref to branches or tags by its name, other refs are ignored
+func (g *GitRefs) add(name plumbing.ReferenceName, hash plumbing.Hash, t *time.Time) {
+	ref := GitRef{Ref: name.String(), Hash: hash.String(), Time: t}
+	remotePrefix := "refs/remotes/" + DefaultGitRemote + "/"
+	switch {
+	case name.IsBranch():
+		ref.Name = name.Short()
+		g.Branches = append(g.Branches, ref)
+	case name.IsTag():
+		ref.Name = name.Short()
+		g.Tags = append(g.Tags, ref)
+	case strings.HasPrefix(name.String(), remotePrefix):
+		ref.Name = strings.TrimPrefix(name.String(), remotePrefix)
+		g.RemoteBranches = append(g.RemoteBranches, ref)
+	}
+}
+
+// syncGitRepo fetch branches and tags of remote into git repo at root,
+// repos sharing a mirror are updated from the mirror synced with remote instead
+func syncGitRepo(ctx stdcontext.Context, root string, r *git.Repository, auth transport.AuthMethod,
+	progress io.Writer) error {
+	if mirrorDir := getGitMirrorOf(root); mirrorDir != "" {
+		return syncGitMirrorOfRepo(ctx, r, mirrorDir, auth, progress)
+	}
+	return fetchGitRepo(ctx, r, auth, progress)
+}
+
+// fetchGitRepo fetch branches and tags of remote, sideband progress is written to progress if not nil
+func fetchGitRepo(ctx stdcontext.Context, r *git.Repository, auth transport.AuthMethod, progress io.Writer) error {
+	err := r.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: DefaultGitRemote,
+		Auth:       auth,
+		Progress:   progress,
+		Tags:       git.AllTags,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	return nil
+}
+
+// fetchGitRefs fetch branches and tags of remote into git repo, tracked by job, leased repos couldn't be fetched
+func (c *context) fetchGitRefs(auth transport.AuthMethod, job *jobContext) (err error) {
+	jobCtx := job.start()
+	defer func() {
+		c.finishJob(job, err)
+	}()
+	// the job may be canceled while pending
+	if err := jobCtx.Err(); err != nil {
+		return err
+	}
+	// fetching updates refs, so it's done in updating status and the previous status is restored after
+	prevStatus, err := leases.tryAcquireRepo(c, "", acceptAnyStatus)
+	if err != nil {
+		return err
+	}
+	defer c.SetRepoStatus(prevStatus)
+	r, err := openGitRepo(c.root)
+	if err != nil {
+		return err
+	}
+	job.SetPhase(JobPhasePull)
+	return syncGitRepo(jobCtx, c.root, r, auth, job)
+}
+
+// ListGitRefs list local and remote branches and tags of a git repo,
+// fetch remote first by a job in worker pool if required
+func ListGitRefs(id uint64, fetch bool, auth transport.AuthMethod) (*GitRefs, error) {
+	ctx := getContext(id)
+	if ctx == nil {
+		return nil, ErrRepoNotFound
+	}
+	ctx.mu.RLock()
+	t, url := ctx.v.Type, ctx.v.URL
+	ctx.mu.RUnlock()
+	if t != TypeGit {
+		return nil, errors.New(fmt.Sprintf("repo %d is not a git repo", id))
+	}
+	if fetch {
+		if leases.isLeased(id) {
+			return nil, ErrRepoLeased
+		}
+		job := createJob(id, JobTypeFetch, nil, cfg.Global().GetPullTimeout(), 0)
+		var fetchErr error
+		err := runQueued(job, t, url, 0, true, func() {
+			fetchErr = ctx.fetchGitRefs(auth, job)
+		})
+		if err != nil {
+			removeJob(job.v.ID)
+			return nil, err
+		}
+		if fetchErr == ErrRepoLeased || fetchErr == ErrRepoUpdating {
+			return nil, fetchErr
+		}
+		if fetchErr != nil {
+			return nil, errors.New(fmt.Sprintf("cannot fetch repo %d! %s", id, fetchErr.Error()))
+		}
+	}
+	r, err := openGitRepo(ctx.root)
+	if err != nil {
+		return nil, err
+	}
+	refs := newGitRefs()
+	if head, err := r.Head(); err == nil && head.Name().IsBranch() {
+		refs.Head = head.Name().Short()
+	}
+	iter, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		hash, err := r.ResolveRevision(plumbing.Revision(ref.Name().String()))
+		if err != nil {
+			// refs to non commit objects are listed as is
+			refs.add(ref.Name(), ref.Hash(), nil)
+			return nil
+		}
+		var t *time.Time
+		if c, err := r.CommitObject(*hash); err == nil {
+			t = &c.Committer.When
+		}
+		refs.add(ref.Name(), *hash, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	refs.sort()
+	return refs, nil
+}
+
+// gitLsRemoteTimeout timeout of listing refs of remote url requested by api
+const gitLsRemoteTimeout = time.Minute
+
+// LsRemoteGit list branches and tags of remote git url without cloning, which is requested by api,
+// so that url is validated like repos to create
+func LsRemoteGit(url string, auth transport.AuthMethod) (*GitRefs, error) {
+	if err := validateURL(url); err != nil {
+		return nil, err
+	}
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), gitLsRemoteTimeout)
+	defer cancel()
+	return lsRemoteGit(ctx, url, auth)
+}
+
+// lsRemoteGit list branches and tags of remote git url without cloning, returns at once when ctx is done
+func lsRemoteGit(ctx stdcontext.Context, url string, auth transport.AuthMethod) (*GitRefs, error) {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.NewClient(ep)
+	if err != nil {
+		return nil, err
+	}
+	// sessions of go-git couldn't be canceled by context, so the session is closed to abort it
+	var mu sync.Mutex
+	var s transport.UploadPackSession
+	done := false
+	closeSession := func() {
+		if closeErr := s.Close(); closeErr != nil {
+			log.Printf("failed to close session of %s! %s\n", url, closeErr.Error())
+		}
+	}
+	type result struct {
+		ar  *packp.AdvRefs
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		session, err := c.NewUploadPackSession(ep, auth)
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+		mu.Lock()
+		s = session
+		if done {
+			closeSession()
+			mu.Unlock()
+			return
+		}
+		mu.Unlock()
+		ar, err := session.AdvertisedReferences()
+		ch <- result{ar: ar, err: err}
+	}()
+	var res result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		res.err = ctx.Err()
+	}
+	mu.Lock()
+	done = true
+	if s != nil {
+		closeSession()
+	}
+	mu.Unlock()
+	if res.err != nil {
+		return nil, res.err
+	}
+	ar := res.ar
+	refs := newGitRefs()
+	for _, symRef := range ar.Capabilities.Get(capability.SymRef) {
+		if parts := strings.SplitN(symRef, ":", 2); len(parts) == 2 && parts[0] == string(plumbing.HEAD) {
+			refs.Head = plumbing.ReferenceName(parts[1]).Short()
+		}
+	}
+	for name, hash := range ar.References {
+		if peeled, ok := ar.Peeled[name]; ok {
+			hash = peeled
+		}
+		refs.add(plumbing.ReferenceName(name), hash, nil)
+	}
+	refs.sort()
+	return refs, nil
+}
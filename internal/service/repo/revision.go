@@ -0,0 +1,218 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minGitHashPrefixLen min length of abbreviated hash, the same as git
+const minGitHashPrefixLen = 4
+
+// gitRelativeDatePattern pattern of relative date like 2.days.ago or 3 hours ago
+var gitRelativeDatePattern = regexp.MustCompile(`^(\d+)[. ](second|minute|hour|day|week|month|year)s?[. ]ago$`)
+
+// isGitHashPrefix is s a possible abbreviated or full commit hash
+func isGitHashPrefix(s string) bool {
+	if len(s) < minGitHashPrefixLen || len(s) > len(plumbing.ZeroHash)*2 {
+		return false
+	}
+	for _, ch := range s {
+		if !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isGitHashMatched is hash equal to or abbreviated by prefix
+func isGitHashMatched(hash string, prefix string) bool {
+	return isGitHashPrefix(prefix) && strings.HasPrefix(hash, strings.ToLower(prefix))
+}
+
+// findGitCommitByPrefix find the commit of abbreviated hash, returns false if no commit-ish object matches,
+// error if the prefix is ambiguous
+func findGitCommitByPrefix(r *git.Repository, prefix string) (plumbing.Hash, bool, error) {
+	prefix = strings.ToLower(prefix)
+	evenPrefix, err := hex.DecodeString(prefix[:len(prefix)&^1])
+	if err != nil {
+		return plumbing.ZeroHash, false, nil
+	}
+	var candidates []plumbing.Hash
+	type prefixStorer interface {
+		HashesWithPrefix(prefix []byte) ([]plumbing.Hash, error)
+	}
+	if ps, ok := r.Storer.(prefixStorer); ok {
+		// pack indexes are loaded lazily by object lookups but not by HashesWithPrefix
+		_ = r.Storer.HasEncodedObject(plumbing.ZeroHash)
+		if candidates, err = ps.HashesWithPrefix(evenPrefix); err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+	} else {
+		iter, err := r.Storer.IterEncodedObjects(plumbing.AnyObject)
+		if err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+		err = iter.ForEach(func(o plumbing.EncodedObject) error {
+			if h := o.Hash(); bytes.HasPrefix(h[:], evenPrefix) {
+				candidates = append(candidates, h)
+			}
+			return nil
+		})
+		if err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+	}
+	found := make(map[plumbing.Hash]bool)
+	var commitHash plumbing.Hash
+	for _, h := range candidates {
+		if !strings.HasPrefix(h.String(), prefix) {
+			continue
+		}
+		if c, err := r.CommitObject(h); err == nil {
+			commitHash = c.Hash
+		} else if tag, err := r.TagObject(h); err == nil {
+			c, err := tag.Commit()
+			if err != nil {
+				continue
+			}
+			commitHash = c.Hash
+		} else {
+			continue
+		}
+		found[commitHash] = true
+	}
+	if len(found) > 1 {
+		return plumbing.ZeroHash, false, errors.New(fmt.Sprintf("abbreviated hash %s is ambiguous", prefix))
+	}
+	return commitHash, len(found) == 1, nil
+}
+
+// parseGitDate parse date of ref@{date}, absolute or relative like 2.days.ago
+func parseGitDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if m := gitRelativeDatePattern.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		now := time.Now()
+		switch m[2] {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		default:
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New(fmt.Sprintf("unsupported date %s, only absolute dates and N.<unit>.ago are supported", s))
+}
+
+// findGitCommitAt find the newest commit not later than t following the first parents from hash,
+// approximates ref@{date} as the reflog is not available
+func findGitCommitAt(r *git.Repository, hash plumbing.Hash, t time.Time) (plumbing.Hash, error) {
+	c, err := r.CommitObject(hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	for c.Committer.When.After(t) {
+		if c.NumParents() == 0 {
+			return plumbing.ZeroHash, errors.New(fmt.Sprintf("no commit at %s", t.Format(time.RFC3339)))
+		}
+		if c, err = c.Parent(0); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	return c.Hash, nil
+}
+
+// findGitRef find the hash of ref by short or full name, in the precedence of git rev-parse
+func findGitRef(r *git.Repository, name string) (plumbing.Hash, bool) {
+	if name == "" {
+		return plumbing.ZeroHash, false
+	}
+	for _, rule := range append([]string{"%s"}, plumbing.RefRevParseRules...) {
+		ref, err := storer.ResolveReference(r.Storer, plumbing.ReferenceName(fmt.Sprintf(rule, name)))
+		if err == nil {
+			return ref.Hash(), true
+		}
+	}
+	return plumbing.ZeroHash, false
+}
+
+// resolveGitExpr resolve revision expression to commit hash, e.g. abbreviated hash, HEAD~3,
+// refs/remotes/origin/main, annotated tag v1.0 or main@{2020-01-01}
+func resolveGitExpr(r *git.Repository, expr string) (plumbing.Hash, error) {
+	expr = strings.TrimSpace(expr)
+	if i := strings.Index(expr, "@{"); i >= 0 {
+		j := strings.IndexByte(expr[i:], '}')
+		if j < 0 {
+			return plumbing.ZeroHash, errors.New(fmt.Sprintf("unclosed @{ in %s", expr))
+		}
+		base, date, suffix := expr[:i], expr[i+2:i+j], expr[i+j+1:]
+		if base == "" {
+			base = plumbing.HEAD.String()
+		}
+		t, err := parseGitDate(date)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		h, err := resolveGitExpr(r, base)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if h, err = findGitCommitAt(r, h, t); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if suffix == "" {
+			return h, nil
+		}
+		return resolveGitExpr(r, h.String()+suffix)
+	}
+	base, suffix := expr, ""
+	if i := strings.IndexAny(expr, "~^"); i >= 0 {
+		base, suffix = expr[:i], expr[i:]
+	}
+	if h, ok := findGitRef(r, base); ok && len(base) < len(plumbing.ZeroHash)*2 {
+		// refs take precedence over abbreviated hashes, e.g. branch cafe
+		expr = h.String() + suffix
+	} else if isGitHashPrefix(base) {
+		h, ok, err := findGitCommitByPrefix(r, base)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if ok && suffix == "" {
+			return h, nil
+		}
+		if ok {
+			expr = h.String() + suffix
+		}
+	}
+	h, err := r.ResolveRevision(plumbing.Revision(expr))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return *h, nil
+}
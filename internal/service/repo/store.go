@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"github.com/utmhikari/repomaster/pkg/util"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// storeFileName the name of metadata store file under repo root,
+// which is not numeric so that it would not be scanned as a repo
+const storeFileName = ".repomaster.json"
+
+// maxDeletedRecords max count of deletion records kept in store
+const maxDeletedRecords = 100
+
+// DeletedItem record of a deleted repo
+type DeletedItem struct {
+	ID        uint64    `json:"id"`
+	Repo      Repo      `json:"repo"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// storeData the content of metadata store file
+type storeData struct {
+	Repos   map[uint64]Repo `json:"repos"`
+	Deleted []DeletedItem   `json:"deleted"`
+}
+
+// storeMu mutex to serialize access of metadata store file
+var storeMu sync.Mutex
+
+// deletedItems records of deleted repos, protected by storeMu
+var deletedItems []DeletedItem
+
+// AuthInfo the non-secret info of auth used by repo
+type AuthInfo struct {
+	Method   string `json:"method"`
+	Username string `json:"username"`
+}
+
+// newAuthInfo get non-secret info of auth
+func newAuthInfo(auth Auth) AuthInfo {
+	switch a := auth.(type) {
+	case *http.BasicAuth:
+		return AuthInfo{Method: a.Name(), Username: a.Username}
+	case *ssh.PublicKeys:
+		return AuthInfo{Method: a.Name(), Username: a.User}
+	case *ssh.Password:
+		return AuthInfo{Method: a.Name(), Username: a.User}
+	case transport.AuthMethod:
+		return AuthInfo{Method: a.Name()}
+	case *models.SvnAuth:
+		if a != nil && a.Username != "" {
+			return AuthInfo{Method: "svn-password", Username: a.Username}
+		}
+	}
+	return AuthInfo{}
+}
+
+// getStorePath get path of metadata store file
+func getStorePath() string {
+	return filepath.Join(cfg.Global().RepoRoot, storeFileName)
+}
+
+// loadStore load repo metadata from store file
+func loadStore() map[uint64]Repo {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	storePath := getStorePath()
+	if !util.IsFile(storePath) {
+		return make(map[uint64]Repo)
+	}
+	var data storeData
+	if err := util.ReadJsonFile(storePath, &data); err != nil {
+		log.Printf("failed to load repo metadata from %s! %s\n", storePath, err.Error())
+		return make(map[uint64]Repo)
+	}
+	if data.Repos == nil {
+		data.Repos = make(map[uint64]Repo)
+	}
+	deletedItems = data.Deleted
+	log.Printf("loaded metadata of %d repos from %s\n", len(data.Repos), storePath)
+	return data.Repos
+}
+
+// saveStore save metadata of all repo contexts to store file
+func saveStore() {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	data := storeData{Repos: make(map[uint64]Repo), Deleted: deletedItems}
+	cache.Range(func(k, v interface{}) bool {
+		id, idOk := k.(uint64)
+		ctx, ctxOk := v.(*context)
+		if !idOk || !ctxOk {
+			return true
+		}
+		ctx.mu.RLock()
+		data.Repos[id] = ctx.v
+		ctx.mu.RUnlock()
+		return true
+	})
+	storePath := getStorePath()
+	if err := util.WriteJsonFile(storePath, &data); err != nil {
+		log.Printf("failed to save repo metadata to %s! %s\n", storePath, err.Error())
+	}
+}
+
+// recordDeletion record a deleted repo to store
+func recordDeletion(id uint64, r Repo) {
+	storeMu.Lock()
+	deletedItems = append(deletedItems, DeletedItem{
+		ID:        id,
+		Repo:      r,
+		DeletedAt: time.Now(),
+	})
+	if len(deletedItems) > maxDeletedRecords {
+		deletedItems = deletedItems[len(deletedItems)-maxDeletedRecords:]
+	}
+	storeMu.Unlock()
+	saveStore()
+}
+
+// GetDeletedRepos get records of deleted repos
+func GetDeletedRepos() []DeletedItem {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	return append([]DeletedItem{}, deletedItems...)
+}
@@ -0,0 +1,655 @@
+package repo
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"github.com/utmhikari/repomaster/pkg/util"
+	"io"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SvnCommand the svn executable
+var SvnCommand = "svn"
+
+// svnHeadRevision default revision of svn
+const svnHeadRevision = "HEAD"
+
+// svnLogPageSize max count of entries listed by one svn log, logs filtered by time or author are listed page by page
+var svnLogPageSize = 100
+
+// svnInfo the xml output of svn info
+type svnInfo struct {
+	Entry struct {
+		Revision    string `xml:"revision,attr"`
+		URL         string `xml:"url"`
+		RelativeURL string `xml:"relative-url"`
+		Repository  struct {
+			Root string `xml:"root"`
+		} `xml:"repository"`
+		Commit struct {
+			Revision string `xml:"revision,attr"`
+			Author   string `xml:"author"`
+		} `xml:"commit"`
+	} `xml:"entry"`
+}
+
+// svnLogEntry the xml output of a svn log entry
+type svnLogEntry struct {
+	Revision string `xml:"revision,attr"`
+	Author   string `xml:"author"`
+	Date     string `xml:"date"`
+	Msg      string `xml:"msg"`
+}
+
+// svnBlame the xml output of svn blame
+type svnBlame struct {
+	Entries []struct {
+		LineNumber int `xml:"line-number,attr"`
+		Commit     *struct {
+			Revision string `xml:"revision,attr"`
+			Author   string `xml:"author"`
+			Date     string `xml:"date"`
+		} `xml:"commit"`
+	} `xml:"target>entry"`
+}
+
+// svnLog the xml output of svn log
+type svnLog struct {
+	Entries []svnLogEntry `xml:"logentry"`
+}
+
+// svnList the xml output of svn list
+type svnList struct {
+	Entries []struct {
+		Kind string `xml:"kind,attr"`
+		Name string `xml:"name"`
+		Size int64  `xml:"size"`
+	} `xml:"list>entry"`
+}
+
+// svnDiffSummary the xml output of svn diff --summarize
+type svnDiffSummary struct {
+	Paths []struct {
+		Item string `xml:"item,attr"`
+		Kind string `xml:"kind,attr"`
+		Path string `xml:",chardata"`
+	} `xml:"paths>path"`
+}
+
+// svnBackend the svn implementation of Backend, based on the svn command
+type svnBackend struct{}
+
+func init() {
+	RegisterBackend(&svnBackend{})
+}
+
+// runSvn run svn command with auth args, returns stdout
+func runSvn(auth *models.SvnAuth, args ...string) ([]byte, error) {
+	return runSvnContext(stdcontext.Background(), auth, args...)
+}
+
+// runSvnContext run svn command with auth args, which is killed when ctx is done, returns stdout.
+// Auth args are inserted after the subcommand, so that callers could end options by -- before positional args
+func runSvnContext(ctx stdcontext.Context, auth *models.SvnAuth, args ...string) ([]byte, error) {
+	if len(args) > 0 {
+		args = append(append([]string{args[0]}, auth.ToArgs()...), args[1:]...)
+	}
+	cmd := exec.CommandContext(ctx, SvnCommand, args...)
+	if stdin := auth.Stdin(); stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		errMsg := strings.TrimSpace(stderr.String())
+		if errMsg == "" {
+			errMsg = err.Error()
+		}
+		return nil, errors.New(fmt.Sprintf("svn %s failed: %s", args[0], errMsg))
+	}
+	return stdout.Bytes(), nil
+}
+
+// toSvnRevision get svn revision spec from revision
+func toSvnRevision(revision Revision) (models.SvnRevision, error) {
+	var r models.SvnRevision
+	switch v := revision.(type) {
+	case nil:
+	case models.SvnRevision:
+		r = v
+	case *models.SvnRevision:
+		if v != nil {
+			r = *v
+		}
+	default:
+		return r, errUnexpectedRevision(TypeSvn, revision)
+	}
+	// values like options would be parsed as options by svn
+	if strings.HasPrefix(r.Revision, "-") || strings.HasPrefix(r.Path, "-") {
+		return r, errors.New(fmt.Sprintf("invalid svn revision %+v", r))
+	}
+	return r, nil
+}
+
+// toSvnAuth get svn auth from auth
+func toSvnAuth(auth Auth) (*models.SvnAuth, error) {
+	switch a := auth.(type) {
+	case nil:
+		return nil, nil
+	case *models.SvnAuth:
+		return a, nil
+	case models.SvnAuth:
+		return &a, nil
+	default:
+		return nil, errUnexpectedAuth(TypeSvn, auth)
+	}
+}
+
+// getSvnRevisionNumber get revision number of svn revision spec
+func getSvnRevisionNumber(revision models.SvnRevision) string {
+	if revision.Revision == "" {
+		return svnHeadRevision
+	}
+	return revision.Revision
+}
+
+// getSvnURL get url of path relative to repository root
+func getSvnURL(info *svnInfo, path string) string {
+	if path == "" {
+		return info.Entry.URL
+	}
+	return strings.TrimRight(info.Entry.Repository.Root, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// getSvnTarget get url@rev target of revision spec
+func getSvnTarget(info *svnInfo, revision models.SvnRevision) string {
+	return getSvnURL(info, revision.Path) + "@" + getSvnRevisionNumber(revision)
+}
+
+// newCommitFromSvnLogEntry convert svn log entry to Commit
+func newCommitFromSvnLogEntry(entry svnLogEntry) Commit {
+	commit := Commit{
+		Hash:    entry.Revision,
+		Message: entry.Msg,
+		Author:  entry.Author,
+	}
+	if t, err := time.Parse(time.RFC3339Nano, entry.Date); err == nil {
+		commit.Time = t
+	}
+	return commit
+}
+
+// Type is svn
+func (b *svnBackend) Type() Type {
+	return TypeSvn
+}
+
+// ParseRevision decodes json of models.SvnRevision
+func (b *svnBackend) ParseRevision(data []byte) (Revision, error) {
+	return parseJsonRevision(data, &models.SvnRevision{})
+}
+
+// info get svn info of the working copy
+func (b *svnBackend) info(root string) (*svnInfo, error) {
+	if root == "" || !util.IsDirectory(filepath.Join(root, ".svn")) {
+		return nil, errors.New("cannot get svn working copy")
+	}
+	output, err := runSvn(nil, "info", "--xml", "--", root)
+	if err != nil {
+		return nil, err
+	}
+	var info svnInfo
+	if err := xml.Unmarshal(output, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// log get svn log of target
+func (b *svnBackend) log(target string, args ...string) ([]svnLogEntry, error) {
+	args = append([]string{"log", "--xml"}, args...)
+	output, err := runSvn(nil, append(args, "--", target)...)
+	if err != nil {
+		return nil, err
+	}
+	var l svnLog
+	if err := xml.Unmarshal(output, &l); err != nil {
+		return nil, err
+	}
+	return l.Entries, nil
+}
+
+// size get size of file at target by svn list
+func (b *svnBackend) size(target string) (int64, error) {
+	output, err := runSvn(nil, "list", "--xml", "--", target)
+	if err != nil {
+		return 0, err
+	}
+	var l svnList
+	if err := xml.Unmarshal(output, &l); err != nil {
+		return 0, err
+	}
+	if len(l.Entries) != 1 || l.Entries[0].Kind != "file" {
+		return 0, errors.New(fmt.Sprintf("%s is not a file", target))
+	}
+	return l.Entries[0].Size, nil
+}
+
+// Open checks if root is a svn working copy
+func (b *svnBackend) Open(root string) error {
+	_, err := b.info(root)
+	return err
+}
+
+// Clone checkout svn repo at revision
+func (b *svnBackend) Clone(
+	ctx stdcontext.Context, root string, url string, revision Revision, auth Auth, progress Progress) error {
+	svnRevision, err := toSvnRevision(revision)
+	if err != nil {
+		return err
+	}
+	svnAuth, err := toSvnAuth(auth)
+	if err != nil {
+		return err
+	}
+	if svnRevision.Path != "" {
+		return errors.New("cannot checkout svn repo with path, use the url instead")
+	}
+	progress.SetPhase(JobPhaseClone)
+	_, err = runSvnContext(ctx, svnAuth, "checkout", "-r", getSvnRevisionNumber(svnRevision), "--", url, root)
+	if err != nil {
+		return err
+	}
+	log.Printf("successfully checkout svn repo to %s", root)
+	return nil
+}
+
+// Head get url and revision info of svn working copy
+func (b *svnBackend) Head(root string) (*Head, error) {
+	info, err := b.info(root)
+	if err != nil {
+		return nil, err
+	}
+	h := Head{
+		URL: info.Entry.URL,
+		Commit: Commit{
+			Hash:   info.Entry.Revision,
+			Ref:    info.Entry.RelativeURL,
+			Author: info.Entry.Commit.Author,
+		},
+	}
+	// the working copy revision may not change the root, so get msg from last changed one
+	entries, err := b.log(root, "-r", info.Entry.Commit.Revision)
+	if err != nil {
+		log.Printf("failed to get svn log of %s, %s\n", root, err.Error())
+	} else if len(entries) > 0 {
+		lastChanged := newCommitFromSvnLogEntry(entries[0])
+		h.Commit.Message = lastChanged.Message
+		h.Commit.Time = lastChanged.Time
+	}
+	return &h, nil
+}
+
+// Checkout update or switch svn working copy to specific revision
+func (b *svnBackend) Checkout(
+	ctx stdcontext.Context, root string, revision Revision, auth Auth, progress Progress) error {
+	svnRevision, err := toSvnRevision(revision)
+	if err != nil {
+		return err
+	}
+	svnAuth, err := toSvnAuth(auth)
+	if err != nil {
+		return err
+	}
+	info, err := b.info(root)
+	if err != nil {
+		return err
+	}
+	// switch to another path if specified, otherwise update, both pull from remote
+	progress.SetPhase(JobPhaseCheckout)
+	pullCtx, cancel := withTimeout(ctx, cfg.Global().GetPullTimeout())
+	defer cancel()
+	revisionNumber := getSvnRevisionNumber(svnRevision)
+	if svnRevision.Path != "" {
+		url := getSvnURL(info, svnRevision.Path)
+		log.Printf("switch repo %s to URL %s...\n", root, url)
+		_, err = runSvnContext(pullCtx, svnAuth, "switch", "-r", revisionNumber, "--", url, root)
+	} else {
+		log.Printf("update repo %s from URL %s...\n", root, info.Entry.URL)
+		_, err = runSvnContext(pullCtx, svnAuth, "update", "-r", revisionNumber, "--", root)
+	}
+	return err
+}
+
+// Clean revert changes and remove unversioned files of svn working copy
+func (b *svnBackend) Clean(root string, url string) error {
+	if _, err := runSvn(nil, "cleanup", "--", root); err != nil {
+		return err
+	}
+	if _, err := runSvn(nil, "revert", "-R", "--", root); err != nil {
+		return err
+	}
+	log.Printf("successfully reverted repo at %s\n", root)
+	if _, err := runSvn(nil, "cleanup", "--remove-unversioned", "--", root); err != nil {
+		return err
+	}
+	log.Printf("successfully cleaned files at repo %s\n", root)
+	return nil
+}
+
+// Diff list changed files between two revisions of svn repo
+func (b *svnBackend) Diff(root string, from Revision, to Revision, options DiffOptions) ([]models.FileChange, error) {
+	fromRevision, err := toSvnRevision(from)
+	if err != nil {
+		return nil, err
+	}
+	toRevision, err := toSvnRevision(to)
+	if err != nil {
+		return nil, err
+	}
+	info, err := b.info(root)
+	if err != nil {
+		return nil, err
+	}
+	oldTarget := getSvnTarget(info, fromRevision)
+	newTarget := getSvnTarget(info, toRevision)
+	output, err := runSvn(nil, "diff", "--summarize", "--xml", "--old="+oldTarget, "--new="+newTarget)
+	if err != nil {
+		return nil, err
+	}
+	var summary svnDiffSummary
+	if err := xml.Unmarshal(output, &summary); err != nil {
+		return nil, err
+	}
+	prefixes := []string{
+		getSvnURL(info, toRevision.Path) + "/",
+		getSvnURL(info, fromRevision.Path) + "/",
+	}
+	var patches map[string]string
+	if options.Patch {
+		output, err := runSvn(nil, "diff", "--old="+oldTarget, "--new="+newTarget)
+		if err != nil {
+			return nil, err
+		}
+		patches = splitSvnPatches(output)
+	}
+	fileChanges := make([]models.FileChange, 0)
+	for _, p := range summary.Paths {
+		if p.Kind == "dir" {
+			continue
+		}
+		fileChange := models.FileChange{Path: p.Path}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(p.Path, prefix) {
+				fileChange.Path = strings.TrimPrefix(p.Path, prefix)
+				break
+			}
+		}
+		if !isPathUnder(fileChange.Path, options.Path) {
+			continue
+		}
+		fileChange.Patch = patches[fileChange.Path]
+		switch p.Item {
+		case "added":
+			fileChange.Action = models.FileChangeAdd
+		case "deleted":
+			fileChange.Action = models.FileChangeDelete
+		default:
+			fileChange.Action = models.FileChangeModify
+		}
+		fileChanges = append(fileChanges, fileChange)
+	}
+	return fileChanges, nil
+}
+
+// splitSvnPatches split output of svn diff to patches by file path
+func splitSvnPatches(output []byte) map[string]string {
+	patches := make(map[string]string)
+	var curPath string
+	var curPatch strings.Builder
+	flush := func() {
+		if curPath != "" {
+			patches[curPath] = curPatch.String()
+		}
+		curPatch.Reset()
+	}
+	for _, line := range strings.SplitAfter(string(output), "\n") {
+		if strings.HasPrefix(line, "Index: ") {
+			flush()
+			curPath = strings.TrimSpace(strings.TrimPrefix(line, "Index: "))
+		}
+		curPatch.WriteString(line)
+	}
+	flush()
+	return patches
+}
+
+// Log list commits of svn working copy
+func (b *svnBackend) Log(root string, options LogOptions) ([]Commit, error) {
+	toRevision, err := toSvnRevision(options.To)
+	if err != nil {
+		return nil, err
+	}
+	info, err := b.info(root)
+	if err != nil {
+		return nil, err
+	}
+	target := root
+	if options.To != nil {
+		target = getSvnTarget(info, toRevision)
+	} else {
+		// the working copy revision rather than HEAD, commits not checked out are excluded
+		toRevision.Revision = info.Entry.Revision
+	}
+	if options.Path != "" {
+		target = strings.TrimRight(getSvnURL(info, toRevision.Path), "/") + "/" +
+			strings.Trim(options.Path, "/") + "@" + getSvnRevisionNumber(toRevision)
+	}
+	upper, lower := getSvnRevisionNumber(toRevision), 1
+	if options.From != nil {
+		fromRevision, err := toSvnRevision(options.From)
+		if err != nil {
+			return nil, err
+		}
+		fromNumber, err := strconv.Atoi(fromRevision.Revision)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("from revision of svn log must be a number, got %s", fromRevision.Revision))
+		}
+		if toNumber, err := strconv.Atoi(toRevision.Revision); err == nil && toNumber <= fromNumber {
+			return nil, nil
+		}
+		lower = fromNumber + 1
+	}
+	// list page by page, the commits to skip are listed at once if not filtered.
+	// The next page starts from the last listed revision rather than the one before it,
+	// which may be out of the history of path
+	pageSize := svnLogPageSize
+	if options.Limit > 0 && !options.hasTimeOrAuthorFilter() && options.Skip+options.Limit < pageSize {
+		pageSize = options.Skip + options.Limit
+	}
+	collector := &logCollector{options: &options}
+	for page := 0; ; page++ {
+		limit := pageSize
+		if page > 0 {
+			limit++
+		}
+		entries, err := b.log(target, "-r", upper+":"+strconv.Itoa(lower), "-l", strconv.Itoa(limit))
+		if err != nil {
+			return nil, err
+		}
+		if page > 0 && len(entries) > 0 && entries[0].Revision == upper {
+			entries = entries[1:]
+		}
+		for _, entry := range entries {
+			commit := newCommitFromSvnLogEntry(entry)
+			// revisions are committed in time order, so the older ones are not matched either
+			if !options.Since.IsZero() && commit.Time.Before(options.Since) {
+				return collector.commits, nil
+			}
+			if !collector.add(commit) {
+				return collector.commits, nil
+			}
+		}
+		if len(entries) < pageSize {
+			return collector.commits, nil
+		}
+		upper = entries[len(entries)-1].Revision
+	}
+}
+
+// Cat get content of file at revision by svn cat
+func (b *svnBackend) Cat(root string, revision Revision, filePath string) (io.ReadCloser, int64, error) {
+	svnRevision, err := toSvnRevision(revision)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := b.info(root)
+	if err != nil {
+		return nil, 0, err
+	}
+	target := getSvnURL(info, svnRevision.Path) + "/" + strings.TrimLeft(filePath, "/") +
+		"@" + getSvnRevisionNumber(svnRevision)
+	output, err := runSvn(nil, "cat", "--", target)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(output)), int64(len(output)), nil
+}
+
+// Resolve resolve revision keyword, date or number to the revision number by svn info
+func (b *svnBackend) Resolve(root string, revision Revision) (*Commit, error) {
+	info, err := b.info(root)
+	if err != nil {
+		return nil, err
+	}
+	target := root
+	if revision != nil {
+		svnRevision, err := toSvnRevision(revision)
+		if err != nil {
+			return nil, err
+		}
+		target = getSvnTarget(info, svnRevision)
+	}
+	output, err := runSvn(nil, "info", "--xml", "--", target)
+	if err != nil {
+		return nil, err
+	}
+	var targetInfo svnInfo
+	if err := xml.Unmarshal(output, &targetInfo); err != nil {
+		return nil, err
+	}
+	commit := Commit{
+		Hash:   targetInfo.Entry.Revision,
+		Ref:    targetInfo.Entry.RelativeURL,
+		Author: targetInfo.Entry.Commit.Author,
+	}
+	entries, err := b.log(target, "-r", targetInfo.Entry.Commit.Revision)
+	if err != nil {
+		log.Printf("failed to get svn log of %s, %s\n", target, err.Error())
+	} else if len(entries) > 0 {
+		lastChanged := newCommitFromSvnLogEntry(entries[0])
+		commit.Message = lastChanged.Message
+		commit.Time = lastChanged.Time
+	}
+	return &commit, nil
+}
+
+// Blame get the last commit changed each line of file by svn blame
+func (b *svnBackend) Blame(root string, revision Revision, filePath string) ([]BlameLine, error) {
+	svnRevision, err := toSvnRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+	info, err := b.info(root)
+	if err != nil {
+		return nil, err
+	}
+	target := getSvnURL(info, svnRevision.Path) + "/" + strings.TrimLeft(filePath, "/") +
+		"@" + getSvnRevisionNumber(svnRevision)
+	// check size before the content is loaded
+	size, err := b.size(target)
+	if err != nil {
+		return nil, err
+	}
+	maxSize := cfg.Global().MaxRawFileSize
+	if size > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes exceeds %d bytes", ErrFileTooLarge, size, maxSize)
+	}
+	content, err := runSvn(nil, "cat", "--", target)
+	if err != nil {
+		return nil, err
+	}
+	output, err := runSvn(nil, "blame", "--xml", "--", target)
+	if err != nil {
+		return nil, err
+	}
+	var blame svnBlame
+	if err := xml.Unmarshal(output, &blame); err != nil {
+		return nil, err
+	}
+	// get messages of the revisions in blame only, rather than the whole history of file
+	args := make([]string, 0)
+	revisions := make(map[string]bool)
+	for _, entry := range blame.Entries {
+		if entry.Commit != nil && !revisions[entry.Commit.Revision] {
+			revisions[entry.Commit.Revision] = true
+			args = append(args, "-r", entry.Commit.Revision)
+		}
+	}
+	var entries []svnLogEntry
+	if len(args) > 0 {
+		if entries, err = b.log(target, args...); err != nil {
+			return nil, err
+		}
+	}
+	messages := make(map[string]string)
+	for _, entry := range entries {
+		messages[entry.Revision] = entry.Msg
+	}
+	texts := strings.Split(string(content), "\n")
+	lines := make([]BlameLine, 0, len(blame.Entries))
+	for i, entry := range blame.Entries {
+		line := BlameLine{Line: entry.LineNumber}
+		if i < len(texts) {
+			line.Text = texts[i]
+		}
+		if entry.Commit != nil {
+			line.Hash = entry.Commit.Revision
+			line.Author = entry.Commit.Author
+			line.Summary = getSummary(messages[entry.Commit.Revision])
+			if t, err := time.Parse(time.RFC3339Nano, entry.Commit.Date); err == nil {
+				line.Time = t
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// CreateSvnRepo create a new svn repo, returns the context id and the checkout job
+func CreateSvnRepo(
+	options *models.SvnRepoCreateOptions, revision models.SvnRevision,
+	labels map[string]string, priority int, isSync bool) (uint64, *Job, error) {
+	if options == nil {
+		return 0, nil, errors.New("checkout options is nil")
+	}
+	return CreateRepo(TypeSvn, options.URL, revision, &options.Auth, labels, priority, isSync)
+}
+
+// UpdateSvnRepo update an existed svn repo, returns the checkout job
+func UpdateSvnRepo(
+	id uint64, revision models.SvnRevision, auth *models.SvnAuth, priority int, isSync bool) (*Job, error) {
+	return UpdateRepo(id, TypeSvn, revision, auth, priority, isSync)
+}
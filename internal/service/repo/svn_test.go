@@ -0,0 +1,223 @@
+package repo
+
+import (
+	stdcontext "context"
+	"errors"
+	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/internal/service/cfg"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mustRunSvn run svn command without auth, fails the test on error
+func mustRunSvn(t *testing.T, args ...string) {
+	t.Helper()
+	if _, err := runSvn(nil, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newSvnFixture create a svn repository served by file:// url with commits:
+// r1 mkdir trunk, r2 add trunk/a.txt, r3 append a line to trunk/a.txt, returns url of trunk
+func newSvnFixture(t *testing.T, dir string) string {
+	t.Helper()
+	repoDir := filepath.Join(dir, "svnrepo")
+	if output, err := exec.Command("svnadmin", "create", repoDir).CombinedOutput(); err != nil {
+		t.Fatalf("svnadmin create failed! %s %s", err.Error(), output)
+	}
+	url := "file://" + filepath.ToSlash(repoDir) + "/trunk"
+	mustRunSvn(t, "mkdir", "-m", "init trunk", url)
+	work := filepath.Join(dir, "work")
+	mustRunSvn(t, "checkout", url, work)
+	filePath := filepath.Join(work, "a.txt")
+	if err := ioutil.WriteFile(filePath, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mustRunSvn(t, "add", filePath)
+	mustRunSvn(t, "commit", "-m", "add a", work)
+	if err := ioutil.WriteFile(filePath, []byte("hello\nworld\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mustRunSvn(t, "commit", "-m", "update a", work)
+	return url
+}
+
+// requireSvn skip test if svn is not installed, unless REPOMASTER_TEST_SVN is set, e.g. in ci
+func requireSvn(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{SvnCommand, "svnadmin"} {
+		if _, err := exec.LookPath(name); err != nil {
+			if os.Getenv("REPOMASTER_TEST_SVN") != "" {
+				t.Fatalf("%s is not installed", name)
+			}
+			t.Skipf("%s is not installed", name)
+		}
+	}
+}
+
+func TestSvnBackendFileURL(t *testing.T) {
+	requireSvn(t)
+	dir := newTempDir(t)
+	url := newSvnFixture(t, dir)
+	b := getBackend(TypeSvn)
+	root := filepath.Join(dir, "wc")
+	ctx := stdcontext.Background()
+
+	// clone
+	if err := b.Clone(ctx, root, url, models.SvnRevision{Revision: "2"}, nil, nopProgress{}); err != nil {
+		t.Fatal(err)
+	}
+	head, err := b.Head(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.URL != url || head.Commit.Hash != "2" || head.Commit.Ref != "^/trunk" || head.Commit.Message != "add a" {
+		t.Fatalf("unexpected head after clone: %+v", head)
+	}
+
+	// log defaults to the working copy revision, with or without path
+	commits, err := b.Log(root, LogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 || commits[0].Hash != "2" || commits[1].Hash != "1" {
+		t.Fatalf("unexpected log of working copy: %+v", commits)
+	}
+	commits, err = b.Log(root, LogOptions{Path: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || commits[0].Hash != "2" {
+		t.Fatalf("unexpected log of a.txt: %+v", commits)
+	}
+
+	// checkout HEAD
+	if err := b.Checkout(ctx, root, models.SvnRevision{}, nil, nopProgress{}); err != nil {
+		t.Fatal(err)
+	}
+	if head, err = b.Head(root); err != nil {
+		t.Fatal(err)
+	}
+	if head.Commit.Hash != "3" || head.Commit.Message != "update a" {
+		t.Fatalf("unexpected head after checkout: %+v", head)
+	}
+
+	// diff
+	changes, err := b.Diff(root, models.SvnRevision{Revision: "2"}, models.SvnRevision{Revision: "3"},
+		DiffOptions{Patch: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Path != "a.txt" || changes[0].Action != models.FileChangeModify ||
+		!strings.Contains(changes[0].Patch, "+world") {
+		t.Fatalf("unexpected diff: %+v", changes)
+	}
+
+	// cat
+	rc, size, err := b.Cat(root, models.SvnRevision{Revision: "2"}, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello\n" || size != int64(len(content)) {
+		t.Fatalf("unexpected content of a.txt at r2: %q (%d bytes)", content, size)
+	}
+
+	// blame
+	lines, err := b.Blame(root, models.SvnRevision{}, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 ||
+		lines[0].Text != "hello" || lines[0].Hash != "2" || lines[0].Summary != "add a" ||
+		lines[1].Text != "world" || lines[1].Hash != "3" || lines[1].Summary != "update a" {
+		t.Fatalf("unexpected blame: %+v", lines)
+	}
+}
+
+func TestSvnLogPages(t *testing.T) {
+	requireSvn(t)
+	dir := newTempDir(t)
+	url := newSvnFixture(t, dir)
+	b := getBackend(TypeSvn)
+	root := filepath.Join(dir, "wc")
+	if err := b.Clone(stdcontext.Background(), root, url, models.SvnRevision{}, nil, nopProgress{}); err != nil {
+		t.Fatal(err)
+	}
+	pageSize := svnLogPageSize
+	svnLogPageSize = 1
+	defer func() {
+		svnLogPageSize = pageSize
+	}()
+	cases := []struct {
+		options LogOptions
+		want    []string
+	}{
+		{LogOptions{}, []string{"3", "2", "1"}},
+		{LogOptions{Limit: 2}, []string{"3", "2"}},
+		{LogOptions{Skip: 1, Limit: 1}, []string{"2"}},
+		{LogOptions{From: models.SvnRevision{Revision: "1"}}, []string{"3", "2"}},
+		{LogOptions{Path: "a.txt", Limit: 5}, []string{"3", "2"}},
+		{LogOptions{Author: "nobody", Limit: 5}, nil},
+		{LogOptions{Since: time.Now().Add(time.Hour), Limit: 5}, nil},
+		{LogOptions{Since: time.Now().Add(-time.Hour), Skip: 1, Limit: 1}, []string{"2"}},
+	}
+	for _, c := range cases {
+		commits, err := b.Log(root, c.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(commits))
+		for _, commit := range commits {
+			got = append(got, commit.Hash)
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Fatalf("expect log %+v listed %v, got %v", c.options, c.want, got)
+		}
+	}
+}
+
+func TestSvnBlameFileTooLarge(t *testing.T) {
+	requireSvn(t)
+	dir := newTempDir(t)
+	url := newSvnFixture(t, dir)
+	b := getBackend(TypeSvn)
+	root := filepath.Join(dir, "wc")
+	if err := b.Clone(stdcontext.Background(), root, url, models.SvnRevision{}, nil, nopProgress{}); err != nil {
+		t.Fatal(err)
+	}
+	c := cfg.Global()
+	maxSize := c.MaxRawFileSize
+	c.MaxRawFileSize = 8
+	defer func() {
+		c.MaxRawFileSize = maxSize
+	}()
+	if _, err := b.Blame(root, models.SvnRevision{}, "a.txt"); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("expect file too large, got %v", err)
+	}
+}
+
+func TestSvnRejectsOptionLikeArgs(t *testing.T) {
+	for _, revision := range []models.SvnRevision{{Revision: "--config-dir=/tmp"}, {Path: "-x"}} {
+		if _, err := toSvnRevision(revision); err == nil {
+			t.Fatalf("expect revision %+v rejected", revision)
+		}
+		if _, _, err := CreateRepo(TypeSvn, "svn://example.com/repo", revision, nil, nil, 0, true); err == nil {
+			t.Fatalf("expect repo at revision %+v rejected", revision)
+		}
+	}
+	for _, url := range []string{"--config-option=x", " -x", ""} {
+		if _, _, err := CreateRepo(TypeSvn, url, models.SvnRevision{}, nil, nil, 0, true); err == nil {
+			t.Fatalf("expect repo of url %q rejected", url)
+		}
+	}
+}
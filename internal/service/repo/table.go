@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/utmhikari/repomaster/internal/models"
+	"github.com/utmhikari/repomaster/pkg/table"
+	"path"
+)
+
+// readTableByRef read and parse csv/tsv table referenced in repo
+func readTableByRef(ref models.RepoFileRef, options table.Options) (*table.Table, error) {
+	if !table.IsDelimitedFile(ref.Path) && options.Delimiter == "" {
+		return nil, errors.New(fmt.Sprintf("%s is not a csv/tsv file, delimiter is required", ref.Path))
+	}
+	content, err := ReadFileByRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	t, err := table.ParseDelimited(ref.Path, content, options)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse table %s of repo %d! %s", ref.Path, ref.ID, err.Error()))
+	}
+	return t, nil
+}
+
+// ReadRepoWorkbook read and parse xlsx workbook referenced in repo,
+// csv/tsv table is read as a workbook of single sheet named by its file name
+func ReadRepoWorkbook(ref models.RepoFileRef, options table.Options) (*table.Workbook, error) {
+	if !table.IsXlsxFile(ref.Path) {
+		t, err := readTableByRef(ref, options)
+		if err != nil {
+			return nil, err
+		}
+		return table.NewWorkbookFromTable(path.Base(ref.Path), t), nil
+	}
+	content, err := ReadFileByRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	w, err := table.ParseXlsx(content, options)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse workbook %s of repo %d! %s", ref.Path, ref.ID, err.Error()))
+	}
+	return w, nil
+}
+
+// DiffRepoWorkbooks sheet/row/cell level diff of xlsx workbooks, matching sheets by name
+func DiffRepoWorkbooks(from models.RepoFileRef, to models.RepoFileRef,
+	options table.Options, diffOptions table.DiffOptions) (*table.WorkbookDiff, error) {
+	if to.Path == "" {
+		to.Path = from.Path
+	}
+	fromWorkbook, err := ReadRepoWorkbook(from, options)
+	if err != nil {
+		return nil, err
+	}
+	toWorkbook, err := ReadRepoWorkbook(to, options)
+	if err != nil {
+		return nil, err
+	}
+	return table.DiffWorkbooks(fromWorkbook, toWorkbook, diffOptions), nil
+}
+
+// DiffRepoTables structured diff of csv/tsv tables, matching rows by primary key
+func DiffRepoTables(from models.RepoFileRef, to models.RepoFileRef,
+	options table.Options, diffOptions table.DiffOptions) (*table.Diff, error) {
+	if to.Path == "" {
+		to.Path = from.Path
+	}
+	fromTable, err := readTableByRef(from, options)
+	if err != nil {
+		return nil, err
+	}
+	toTable, err := readTableByRef(to, options)
+	if err != nil {
+		return nil, err
+	}
+	return table.DiffTables(fromTable, toTable, diffOptions)
+}
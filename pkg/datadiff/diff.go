@@ -0,0 +1,300 @@
+package datadiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxLcsCells max cells of lcs table for matching unkeyed array elements,
+// arrays exceeding it are matched by index
+const maxLcsCells = 1 << 20
+
+// Op operation of a changed value
+type Op string
+
+const (
+	OpAdd     Op = "add"
+	OpRemove  Op = "remove"
+	OpChange  Op = "change"
+	OpReorder Op = "reorder"
+)
+
+// Options options for semantic diff
+type Options struct {
+	// IgnoreKeyOrder is whether to ignore the order of object keys, otherwise reordered keys are reported
+	IgnoreKeyOrder bool `json:"ignoreKeyOrder"`
+	// ArrayKey is the field to match elements of arrays whose elements are all objects with unique values of it,
+	// arrays are matched by element otherwise
+	ArrayKey string `json:"arrayKey"`
+}
+
+// Change a changed value, path is json pointer in the new document except removed values,
+// old path is json pointer in the old document if different.
+// Old and new are the key orders of object on reorder
+type Change struct {
+	Op      Op          `json:"op"`
+	Path    string      `json:"path"`
+	OldPath string      `json:"oldPath,omitempty"`
+	Old     interface{} `json:"old"`
+	New     interface{} `json:"new"`
+}
+
+// escapePointerToken escape reference token of json pointer
+func escapePointerToken(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+// pointer a pair of json pointers in the old and new documents
+type pointer struct {
+	old string
+	new string
+}
+
+// child get pointer of child by tokens in the old and new documents
+func (p pointer) child(oldToken string, newToken string) pointer {
+	return pointer{
+		old: p.old + "/" + escapePointerToken(oldToken),
+		new: p.new + "/" + escapePointerToken(newToken),
+	}
+}
+
+// differ diff state of two documents
+type differ struct {
+	options Options
+	changes []Change
+}
+
+// add report a change at pointer
+func (d *differ) add(op Op, p pointer, old interface{}, new interface{}) {
+	c := Change{Op: op, Path: p.new, Old: old, New: new}
+	if op == OpRemove {
+		c.Path = p.old
+	} else if op != OpAdd && p.old != p.new {
+		c.OldPath = p.old
+	}
+	d.changes = append(d.changes, c)
+}
+
+// isNumberEqual are two json numbers equal in value
+func isNumberEqual(a json.Number, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	fa, errA := a.Float64()
+	fb, errB := b.Float64()
+	return errA == nil && errB == nil && fa == fb
+}
+
+// isEqual are two values semantic equal regardless of the order of object keys
+func isEqual(a interface{}, b interface{}) bool {
+	switch ta := a.(type) {
+	case *Object:
+		tb, ok := b.(*Object)
+		if !ok || len(ta.Keys) != len(tb.Keys) {
+			return false
+		}
+		for key, va := range ta.Values {
+			vb, ok := tb.Values[key]
+			if !ok || !isEqual(va, vb) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		tb, ok := b.([]interface{})
+		if !ok || len(ta) != len(tb) {
+			return false
+		}
+		for i := range ta {
+			if !isEqual(ta[i], tb[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		tb, ok := b.(json.Number)
+		return ok && isNumberEqual(ta, tb)
+	default:
+		return a == b
+	}
+}
+
+// diffValue diff two values at pointer
+func (d *differ) diffValue(p pointer, old interface{}, new interface{}) {
+	if oldObject, ok := old.(*Object); ok {
+		if newObject, ok := new.(*Object); ok {
+			d.diffObject(p, oldObject, newObject)
+			return
+		}
+	}
+	if oldArray, ok := old.([]interface{}); ok {
+		if newArray, ok := new.([]interface{}); ok {
+			d.diffArray(p, oldArray, newArray)
+			return
+		}
+	}
+	if !isEqual(old, new) {
+		d.add(OpChange, p, old, new)
+	}
+}
+
+// diffObject diff two objects by keys
+func (d *differ) diffObject(p pointer, old *Object, new *Object) {
+	var oldCommonKeys, newCommonKeys []string
+	for _, key := range old.Keys {
+		if _, ok := new.Values[key]; !ok {
+			d.add(OpRemove, p.child(key, key), old.Values[key], nil)
+		} else {
+			oldCommonKeys = append(oldCommonKeys, key)
+		}
+	}
+	for _, key := range new.Keys {
+		if _, ok := old.Values[key]; ok {
+			newCommonKeys = append(newCommonKeys, key)
+			d.diffValue(p.child(key, key), old.Values[key], new.Values[key])
+		} else {
+			d.add(OpAdd, p.child(key, key), nil, new.Values[key])
+		}
+	}
+	if d.options.IgnoreKeyOrder {
+		return
+	}
+	for i := range oldCommonKeys {
+		if oldCommonKeys[i] != newCommonKeys[i] {
+			d.add(OpReorder, p, oldCommonKeys, newCommonKeys)
+			return
+		}
+	}
+}
+
+// getArrayKeys get the key values of array elements, false if not all elements are objects with unique keys
+func (d *differ) getArrayKeys(a []interface{}) ([]string, bool) {
+	keys := make([]string, len(a))
+	existed := make(map[string]bool)
+	for i, element := range a {
+		o, ok := element.(*Object)
+		if !ok {
+			return nil, false
+		}
+		value, ok := o.Values[d.options.ArrayKey]
+		if !ok {
+			return nil, false
+		}
+		switch value.(type) {
+		case *Object, []interface{}:
+			return nil, false
+		}
+		key := fmt.Sprintf("%T:%v", value, value)
+		if existed[key] {
+			return nil, false
+		}
+		existed[key] = true
+		keys[i] = key
+	}
+	return keys, true
+}
+
+// diffKeyedArray diff array elements matched by key values
+func (d *differ) diffKeyedArray(p pointer, old []interface{}, new []interface{}, oldKeys []string, newKeys []string) {
+	newIndexes := make(map[string]int)
+	for i, key := range newKeys {
+		newIndexes[key] = i
+	}
+	oldIndexes := make(map[string]int)
+	for i, key := range oldKeys {
+		oldIndexes[key] = i
+		if _, ok := newIndexes[key]; !ok {
+			d.add(OpRemove, p.child(strconv.Itoa(i), ""), old[i], nil)
+		}
+	}
+	for i, key := range newKeys {
+		if j, ok := oldIndexes[key]; ok {
+			d.diffValue(p.child(strconv.Itoa(j), strconv.Itoa(i)), old[j], new[i])
+		} else {
+			d.add(OpAdd, p.child("", strconv.Itoa(i)), nil, new[i])
+		}
+	}
+}
+
+// matchElements match equal elements of two arrays by longest common subsequence,
+// returns index pairs in order
+func matchElements(old []interface{}, new []interface{}) [][2]int {
+	n, m := len(old), len(new)
+	lengths := make([][]int, n+1)
+	for i := range lengths {
+		lengths[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if isEqual(old[i], new[j]) {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	var pairs [][2]int
+	i, j := 0, 0
+	for i < n && j < m {
+		if isEqual(old[i], new[j]) {
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		} else if lengths[i+1][j] >= lengths[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return pairs
+}
+
+// diffArraySegment diff unmatched elements between matched ones by index
+func (d *differ) diffArraySegment(p pointer, old []interface{}, new []interface{},
+	oldStart int, oldEnd int, newStart int, newEnd int) {
+	i, j := oldStart, newStart
+	for ; i < oldEnd && j < newEnd; i, j = i+1, j+1 {
+		d.diffValue(p.child(strconv.Itoa(i), strconv.Itoa(j)), old[i], new[j])
+	}
+	for ; i < oldEnd; i++ {
+		d.add(OpRemove, p.child(strconv.Itoa(i), ""), old[i], nil)
+	}
+	for ; j < newEnd; j++ {
+		d.add(OpAdd, p.child("", strconv.Itoa(j)), nil, new[j])
+	}
+}
+
+// diffArray diff two arrays, elements are matched by key if possible, or by equality and position
+func (d *differ) diffArray(p pointer, old []interface{}, new []interface{}) {
+	if d.options.ArrayKey != "" {
+		oldKeys, oldOk := d.getArrayKeys(old)
+		newKeys, newOk := d.getArrayKeys(new)
+		if oldOk && newOk && (len(old) > 0 || len(new) > 0) {
+			d.diffKeyedArray(p, old, new, oldKeys, newKeys)
+			return
+		}
+	}
+	if len(old)*len(new) > maxLcsCells {
+		d.diffArraySegment(p, old, new, 0, len(old), 0, len(new))
+		return
+	}
+	i, j := 0, 0
+	for _, pair := range matchElements(old, new) {
+		d.diffArraySegment(p, old, new, i, pair[0], j, pair[1])
+		// equal elements may still differ in the order of object keys
+		d.diffValue(p.child(strconv.Itoa(pair[0]), strconv.Itoa(pair[1])), old[pair[0]], new[pair[1]])
+		i, j = pair[0]+1, pair[1]+1
+	}
+	d.diffArraySegment(p, old, new, i, len(old), j, len(new))
+}
+
+// Diff semantic diff of two parsed documents
+func Diff(old interface{}, new interface{}, options Options) []Change {
+	d := &differ{options: options, changes: make([]Change, 0)}
+	d.diffValue(pointer{}, old, new)
+	return d.changes
+}
@@ -0,0 +1,116 @@
+package datadiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// mustParseJSON parse json content, fail on error
+func mustParseJSON(t *testing.T, content string) interface{} {
+	t.Helper()
+	v, err := ParseJSON([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		name    string
+		old     string
+		new     string
+		options Options
+		expect  string
+	}{
+		{"same", `{"a":[1,{"b":null}]}`, `{"a":[1.0,{"b":null}]}`, Options{}, `[]`},
+		{"objects", `{"a":1,"b":{"c":"x","d":true},"e":null}`, `{"b":{"c":"y","d":true},"a":1.0,"f":[1]}`,
+			Options{IgnoreKeyOrder: true},
+			`[{"op":"remove","path":"/e","old":null,"new":null},
+			{"op":"change","path":"/b/c","old":"x","new":"y"},
+			{"op":"add","path":"/f","old":null,"new":[1]}]`},
+		{"reordered keys", `{"a":1,"b":{"c":"x","d":true},"e":null}`, `{"b":{"d":true,"c":"x"},"a":1.0}`, Options{},
+			`[{"op":"remove","path":"/e","old":null,"new":null},
+			{"op":"reorder","path":"/b","old":["c","d"],"new":["d","c"]},
+			{"op":"reorder","path":"","old":["a","b"],"new":["b","a"]}]`},
+		{"reordered keys of equal elements", `[{"a":1,"b":2}]`, `[{"b":2,"a":1}]`, Options{},
+			`[{"op":"reorder","path":"/0","old":["a","b"],"new":["b","a"]}]`},
+		{"escaped pointer", `{"a/b":{"m~n":1}}`, `{"a/b":{"m~n":2}}`, Options{},
+			`[{"op":"change","path":"/a~1b/m~0n","old":1,"new":2}]`},
+		{"changed type", `{"a":{"b":1}}`, `{"a":[1]}`, Options{},
+			`[{"op":"change","path":"/a","old":{"b":1},"new":[1]}]`},
+		{"keyed array", `{"items":[{"id":1,"v":"a"},{"id":2,"v":"b"},{"id":3,"v":"c"}]}`,
+			`{"items":[{"id":3,"v":"c"},{"id":1,"v":"x"},{"id":4,"v":"d"}]}`, Options{ArrayKey: "id"},
+			`[{"op":"remove","path":"/items/1","old":{"id":2,"v":"b"},"new":null},
+			{"op":"change","path":"/items/1/v","oldPath":"/items/0/v","old":"a","new":"x"},
+			{"op":"add","path":"/items/2","old":null,"new":{"id":4,"v":"d"}}]`},
+		{"keyed array of typed keys", `[{"id":1,"v":1},{"id":"1","v":2}]`, `[{"id":"1","v":2},{"id":1,"v":3}]`,
+			Options{ArrayKey: "id"},
+			`[{"op":"change","path":"/1/v","oldPath":"/0/v","old":1,"new":3}]`},
+		{"duplicated array keys", `[{"id":1,"v":1},{"id":1,"v":2}]`, `[{"id":1,"v":2}]`, Options{ArrayKey: "id"},
+			`[{"op":"remove","path":"/0","old":{"id":1,"v":1},"new":null}]`},
+		{"missing array keys", `[{"id":1,"v":1},2]`, `[2]`, Options{ArrayKey: "id"},
+			`[{"op":"remove","path":"/0","old":{"id":1,"v":1},"new":null}]`},
+		{"unkeyed array", `[1,2,3,4]`, `[2,3,5,4,6]`, Options{},
+			`[{"op":"remove","path":"/0","old":1,"new":null},
+			{"op":"add","path":"/2","old":null,"new":5},
+			{"op":"add","path":"/4","old":null,"new":6}]`},
+		{"changed element between equal ones", `[1,{"a":1},3]`, `[0,1,{"a":2},3]`, Options{},
+			`[{"op":"add","path":"/0","old":null,"new":0},
+			{"op":"change","path":"/2/a","oldPath":"/1/a","old":1,"new":2}]`},
+	}
+	for _, c := range cases {
+		changes := Diff(mustParseJSON(t, c.old), mustParseJSON(t, c.new), c.options)
+		got, err := json.Marshal(changes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expect bytes.Buffer
+		if err := json.Compact(&expect, []byte(c.expect)); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != expect.String() {
+			t.Fatalf("%s: expect changes %s, got %s", c.name, expect.String(), got)
+		}
+	}
+}
+
+func TestDiffYAMLWithJSON(t *testing.T) {
+	old, err := ParseYAML([]byte("a: 1\nb: [x, {c: 2.5}]\nd: ~\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(old, mustParseJSON(t, `{"a":1.0,"b":["x",{"c":2.50}],"d":null}`), Options{}); len(changes) != 0 {
+		t.Fatalf("expect no changes, got %+v", changes)
+	}
+}
+
+func TestDiffLargeArrayByIndex(t *testing.T) {
+	// arrays exceeding the lcs table are matched by index, so a shifted array changes everywhere
+	n := 1025
+	oldValues, newValues := make([]string, n), make([]string, n)
+	for i := 0; i < n; i++ {
+		oldValues[i] = strconv.Itoa(i)
+		newValues[i] = strconv.Itoa(i - 1)
+	}
+	old := mustParseJSON(t, "["+strings.Join(oldValues, ",")+"]")
+	new := mustParseJSON(t, "["+strings.Join(newValues, ",")+"]")
+	changes := Diff(old, new, Options{})
+	if len(changes) != n {
+		t.Fatalf("expect %d changes, got %d", n, len(changes))
+	}
+	for i, c := range changes {
+		if c.Op != OpChange || c.Path != "/"+strconv.Itoa(i) {
+			t.Fatalf("unexpected change %+v at %d", c, i)
+		}
+	}
+	// smaller arrays are matched by lcs
+	changes = Diff(mustParseJSON(t, "["+strings.Join(oldValues[:100], ",")+"]"),
+		mustParseJSON(t, "["+strings.Join(newValues[:100], ",")+"]"), Options{})
+	if len(changes) != 2 || changes[0].Op != OpAdd || changes[1].Op != OpRemove {
+		t.Fatalf("expect an added and a removed element, got %+v", changes)
+	}
+}
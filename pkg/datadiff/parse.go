@@ -0,0 +1,233 @@
+package datadiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Object a json object with keys in document order
+type Object struct {
+	Keys   []string
+	Values map[string]interface{}
+}
+
+// newObject create an empty object
+func newObject() *Object {
+	return &Object{Keys: make([]string, 0), Values: make(map[string]interface{})}
+}
+
+// set set value of key, appends key if not existed
+func (o *Object) set(key string, value interface{}) {
+	if _, ok := o.Values[key]; !ok {
+		o.Keys = append(o.Keys, key)
+	}
+	o.Values[key] = value
+}
+
+// MarshalJSON marshal object with keys in document order
+func (o *Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.Keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(o.Values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// IsDataFile is the file a json or yaml file by extension
+func IsDataFile(filePath string) bool {
+	ext := strings.ToLower(path.Ext(filePath))
+	return ext == ".json" || ext == ".yaml" || ext == ".yml"
+}
+
+// Parse parse json or yaml content by file extension,
+// values are nil, bool, json.Number, string, []interface{} or *Object
+func Parse(filePath string, content []byte) (interface{}, error) {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".json":
+		return ParseJSON(content)
+	case ".yaml", ".yml":
+		return ParseYAML(content)
+	}
+	return nil, errors.New(fmt.Sprintf("%s is not a json/yaml file", filePath))
+}
+
+// ParseJSON parse json content with object keys in order
+func ParseJSON(content []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	v, err := decodeJSONValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after top-level value")
+	}
+	return v, nil
+}
+
+// decodeJSONValue decode next json value from token stream
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			o := newObject()
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, ok := keyToken.(string)
+				if !ok {
+					return nil, errors.New(fmt.Sprintf("unexpected object key %v", keyToken))
+				}
+				value, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				o.set(key, value)
+			}
+			if _, err = decoder.Token(); err != nil {
+				return nil, err
+			}
+			return o, nil
+		}
+		if t == '[' {
+			a := make([]interface{}, 0)
+			for decoder.More() {
+				value, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				a = append(a, value)
+			}
+			if _, err = decoder.Token(); err != nil {
+				return nil, err
+			}
+			return a, nil
+		}
+		return nil, errors.New(fmt.Sprintf("unexpected delimiter %v", t))
+	default:
+		return t, nil
+	}
+}
+
+// yamlValue yaml value decoded with mapping keys in order
+type yamlValue struct {
+	value interface{}
+}
+
+// UnmarshalYAML decode mappings as yaml.MapSlice, including the top-level one
+func (v *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var i interface{}
+	if err := unmarshal(&i); err != nil {
+		return err
+	}
+	switch i.(type) {
+	case map[interface{}]interface{}:
+		var m yaml.MapSlice
+		if err := unmarshal(&m); err != nil {
+			return err
+		}
+		v.value = m
+	case []interface{}:
+		var a []yamlValue
+		if err := unmarshal(&a); err != nil {
+			return err
+		}
+		values := make([]interface{}, len(a))
+		for j := range a {
+			values[j] = a[j].value
+		}
+		v.value = values
+	default:
+		v.value = i
+	}
+	return nil
+}
+
+// ParseYAML parse yaml content with mapping keys in order, only the first document is parsed
+func ParseYAML(content []byte) (interface{}, error) {
+	var v yamlValue
+	if err := yaml.Unmarshal(content, &v); err != nil {
+		return nil, err
+	}
+	return normalizeYAMLValue(v.value), nil
+}
+
+// normalizeYAMLValue convert decoded yaml value to json compatible value
+func normalizeYAMLValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		o := newObject()
+		for _, item := range t {
+			o.set(fmt.Sprint(item.Key), normalizeYAMLValue(item.Value))
+		}
+		return o
+	case map[interface{}]interface{}:
+		// keys of mapping decoded without order are sorted
+		o := newObject()
+		var keys []string
+		values := make(map[string]interface{})
+		for key, value := range t {
+			k := fmt.Sprint(key)
+			keys = append(keys, k)
+			values[k] = value
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			o.set(k, normalizeYAMLValue(values[k]))
+		}
+		return o
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i := range t {
+			a[i] = normalizeYAMLValue(t[i])
+		}
+		return a
+	case int:
+		return json.Number(strconv.Itoa(t))
+	case int64:
+		return json.Number(strconv.FormatInt(t, 10))
+	case uint64:
+		return json.Number(strconv.FormatUint(t, 10))
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return strconv.FormatFloat(t, 'g', -1, 64)
+		}
+		return json.Number(strconv.FormatFloat(t, 'g', -1, 64))
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	case nil, bool, string:
+		return t
+	}
+	return fmt.Sprint(v)
+}
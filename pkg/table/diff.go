@@ -0,0 +1,362 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultRenameThreshold min ratio of equal values to treat a removed and an added column as renamed
+const DefaultRenameThreshold = 0.8
+
+// DiffOptions options for diffing two tables
+type DiffOptions struct {
+	// Key is the primary key column to match rows, the first column if empty
+	Key string `json:"key"`
+	// RenameThreshold is the min ratio of equal values of renamed columns, default if not positive
+	RenameThreshold float64 `json:"renameThreshold"`
+	// SheetKeys are the key columns by sheet name of workbooks, Key is used if not specified
+	SheetKeys map[string]string `json:"sheetKeys"`
+}
+
+// ColumnRename a column renamed from old table to new table
+type ColumnRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// SchemaChange changes of columns
+type SchemaChange struct {
+	Added   []string       `json:"added"`
+	Removed []string       `json:"removed"`
+	Renamed []ColumnRename `json:"renamed"`
+}
+
+// Row a row with its key and values by column
+type Row struct {
+	Key    string            `json:"key"`
+	Values map[string]string `json:"values"`
+}
+
+// CellChange a changed cell of row, column is named as the new table
+type CellChange struct {
+	Column string `json:"column"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+// RowChange changed cells of a row
+type RowChange struct {
+	Key   string       `json:"key"`
+	Cells []CellChange `json:"cells"`
+}
+
+// Diff the structured diff of two tables
+type Diff struct {
+	Key           string       `json:"key"`
+	Schema        SchemaChange `json:"schema"`
+	Added         []Row        `json:"added"`
+	Removed       []Row        `json:"removed"`
+	Changed       []RowChange  `json:"changed"`
+	DuplicateKeys []string     `json:"duplicateKeys"`
+}
+
+// IsEmpty is there no difference
+func (d *Diff) IsEmpty() bool {
+	return len(d.Schema.Added) == 0 && len(d.Schema.Removed) == 0 && len(d.Schema.Renamed) == 0 &&
+		len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// keyedRows rows of table indexed by key
+type keyedRows struct {
+	keys          []string
+	rows          map[string][]string
+	duplicateKeys []string
+}
+
+// suffixDuplicates make names unique by suffixing duplicated ones by occurrence like name#2,
+// suffixes taken by other names are skipped
+func suffixDuplicates(names []string) []string {
+	taken := make(map[string]bool)
+	for _, name := range names {
+		taken[name] = true
+	}
+	unique := make([]string, len(names))
+	counts := make(map[string]int)
+	for i, name := range names {
+		counts[name]++
+		unique[i] = name
+		for counts[name] > 1 {
+			suffixed := name + "#" + strconv.Itoa(counts[name])
+			if !taken[suffixed] {
+				taken[suffixed] = true
+				unique[i] = suffixed
+				break
+			}
+			counts[name]++
+		}
+	}
+	return unique
+}
+
+// getColumnNames get unique column names of header, empty names are named by position
+func getColumnNames(header []string) []string {
+	names := make([]string, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+		}
+		names[i] = name
+	}
+	return suffixDuplicates(names)
+}
+
+// indexOf index of name in names, -1 if not found
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// isBlankRow are all cells of row blank
+func isBlankRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// indexRows index rows by key column, duplicated keys are suffixed by occurrence
+func indexRows(t *Table, keyColumn int) *keyedRows {
+	k := &keyedRows{rows: make(map[string][]string)}
+	var rows [][]string
+	var keys []string
+	counts := make(map[string]int)
+	for _, row := range t.Rows {
+		if isBlankRow(row) {
+			continue
+		}
+		key := Cell(row, keyColumn)
+		counts[key]++
+		if counts[key] == 2 {
+			k.duplicateKeys = append(k.duplicateKeys, key)
+		}
+		rows = append(rows, row)
+		keys = append(keys, key)
+	}
+	k.keys = suffixDuplicates(keys)
+	for i, key := range k.keys {
+		k.rows[key] = rows[i]
+	}
+	return k
+}
+
+// toRow create row with values by column names
+func toRow(key string, row []string, names []string) Row {
+	values := make(map[string]string)
+	for i, name := range names {
+		values[name] = Cell(row, i)
+	}
+	return Row{Key: key, Values: values}
+}
+
+// columnPair a column of old table matched to a column of new table
+type columnPair struct {
+	oldIndex int
+	newIndex int
+}
+
+// detectRenames pair removed and added columns with mostly equal values of matched rows
+func detectRenames(oldRows *keyedRows, newRows *keyedRows,
+	removed []int, added []int, threshold float64) map[int]int {
+	renames := make(map[int]int)
+	var matchedKeys []string
+	for _, key := range oldRows.keys {
+		if _, ok := newRows.rows[key]; ok {
+			matchedKeys = append(matchedKeys, key)
+		}
+	}
+	if len(matchedKeys) == 0 {
+		return renames
+	}
+	pairedAdded := make(map[int]bool)
+	for _, oldIndex := range removed {
+		bestNewIndex, bestScore := -1, 0.0
+		for _, newIndex := range added {
+			if pairedAdded[newIndex] {
+				continue
+			}
+			equalCount := 0
+			for _, key := range matchedKeys {
+				if Cell(oldRows.rows[key], oldIndex) == Cell(newRows.rows[key], newIndex) {
+					equalCount++
+				}
+			}
+			score := float64(equalCount) / float64(len(matchedKeys))
+			if score > bestScore {
+				bestNewIndex, bestScore = newIndex, score
+			}
+		}
+		if bestNewIndex >= 0 && bestScore >= threshold {
+			renames[oldIndex] = bestNewIndex
+			pairedAdded[bestNewIndex] = true
+		}
+	}
+	return renames
+}
+
+// DiffTables diff two tables, matching rows by primary key column
+func DiffTables(oldTable *Table, newTable *Table, options DiffOptions) (*Diff, error) {
+	oldNames := getColumnNames(oldTable.Header)
+	newNames := getColumnNames(newTable.Header)
+	key := options.Key
+	if key == "" {
+		if len(newNames) == 0 {
+			return nil, errors.New("cannot get key column of empty header")
+		}
+		key = newNames[0]
+	}
+	oldKeyIndex, newKeyIndex := indexOf(oldNames, key), indexOf(newNames, key)
+	if oldKeyIndex < 0 || newKeyIndex < 0 {
+		return nil, errors.New(fmt.Sprintf("key column %s is not found in both tables", key))
+	}
+	threshold := options.RenameThreshold
+	if threshold <= 0 {
+		threshold = DefaultRenameThreshold
+	}
+	oldRows, newRows := indexRows(oldTable, oldKeyIndex), indexRows(newTable, newKeyIndex)
+	d := &Diff{
+		Key: key,
+		Schema: SchemaChange{
+			Added:   make([]string, 0),
+			Removed: make([]string, 0),
+			Renamed: make([]ColumnRename, 0),
+		},
+		Added:         make([]Row, 0),
+		Removed:       make([]Row, 0),
+		Changed:       make([]RowChange, 0),
+		DuplicateKeys: make([]string, 0),
+	}
+	// schema
+	var removed, added []int
+	for i, name := range oldNames {
+		if indexOf(newNames, name) < 0 {
+			removed = append(removed, i)
+		}
+	}
+	for i, name := range newNames {
+		if indexOf(oldNames, name) < 0 {
+			added = append(added, i)
+		}
+	}
+	renames := detectRenames(oldRows, newRows, removed, added, threshold)
+	renamedTo := make(map[int]int)
+	for oldIndex, newIndex := range renames {
+		renamedTo[newIndex] = oldIndex
+	}
+	for _, i := range removed {
+		if _, ok := renames[i]; !ok {
+			d.Schema.Removed = append(d.Schema.Removed, oldNames[i])
+		}
+	}
+	var pairs []columnPair
+	for i, name := range newNames {
+		if oldIndex, ok := renamedTo[i]; ok {
+			d.Schema.Renamed = append(d.Schema.Renamed, ColumnRename{From: oldNames[oldIndex], To: name})
+			pairs = append(pairs, columnPair{oldIndex: oldIndex, newIndex: i})
+		} else if oldIndex := indexOf(oldNames, name); oldIndex >= 0 {
+			pairs = append(pairs, columnPair{oldIndex: oldIndex, newIndex: i})
+		} else {
+			d.Schema.Added = append(d.Schema.Added, name)
+		}
+	}
+	// rows
+	for _, rowKey := range oldRows.keys {
+		oldRow := oldRows.rows[rowKey]
+		newRow, ok := newRows.rows[rowKey]
+		if !ok {
+			d.Removed = append(d.Removed, toRow(rowKey, oldRow, oldNames))
+			continue
+		}
+		var cells []CellChange
+		for _, pair := range pairs {
+			oldValue, newValue := Cell(oldRow, pair.oldIndex), Cell(newRow, pair.newIndex)
+			if oldValue != newValue {
+				cells = append(cells, CellChange{Column: newNames[pair.newIndex], Old: oldValue, New: newValue})
+			}
+		}
+		if len(cells) > 0 {
+			d.Changed = append(d.Changed, RowChange{Key: rowKey, Cells: cells})
+		}
+	}
+	for _, rowKey := range newRows.keys {
+		if _, ok := oldRows.rows[rowKey]; !ok {
+			d.Added = append(d.Added, toRow(rowKey, newRows.rows[rowKey], newNames))
+		}
+	}
+	d.DuplicateKeys = append(d.DuplicateKeys, oldRows.duplicateKeys...)
+	for _, duplicateKey := range newRows.duplicateKeys {
+		if indexOf(d.DuplicateKeys, duplicateKey) < 0 {
+			d.DuplicateKeys = append(d.DuplicateKeys, duplicateKey)
+		}
+	}
+	return d, nil
+}
+
+// SheetDiff diff of a sheet existed in both workbooks
+type SheetDiff struct {
+	Name  string `json:"name"`
+	Diff  *Diff  `json:"diff,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// WorkbookDiff the sheet level diff of two workbooks, only changed sheets are listed
+type WorkbookDiff struct {
+	AddedSheets   []string    `json:"addedSheets"`
+	RemovedSheets []string    `json:"removedSheets"`
+	Sheets        []SheetDiff `json:"sheets"`
+}
+
+// DiffWorkbooks diff sheets of two workbooks matched by name,
+// the error of a sheet which cannot be diffed is reported in its sheet diff
+func DiffWorkbooks(oldWorkbook *Workbook, newWorkbook *Workbook, options DiffOptions) *WorkbookDiff {
+	d := &WorkbookDiff{
+		AddedSheets:   make([]string, 0),
+		RemovedSheets: make([]string, 0),
+		Sheets:        make([]SheetDiff, 0),
+	}
+	for _, oldSheet := range oldWorkbook.Sheets {
+		if newWorkbook.GetSheet(oldSheet.Name) == nil {
+			d.RemovedSheets = append(d.RemovedSheets, oldSheet.Name)
+		}
+	}
+	for _, newSheet := range newWorkbook.Sheets {
+		oldSheet := oldWorkbook.GetSheet(newSheet.Name)
+		if oldSheet == nil {
+			d.AddedSheets = append(d.AddedSheets, newSheet.Name)
+			continue
+		}
+		oldTable, newTable := oldSheet.Table(), newSheet.Table()
+		if len(oldTable.Header) == 0 && len(newTable.Header) == 0 {
+			continue
+		}
+		sheetOptions := options
+		if key, ok := options.SheetKeys[newSheet.Name]; ok {
+			sheetOptions.Key = key
+		}
+		sheetDiff, err := DiffTables(oldTable, newTable, sheetOptions)
+		if err != nil {
+			d.Sheets = append(d.Sheets, SheetDiff{Name: newSheet.Name, Error: err.Error()})
+		} else if !sheetDiff.IsEmpty() {
+			d.Sheets = append(d.Sheets, SheetDiff{Name: newSheet.Name, Diff: sheetDiff})
+		}
+	}
+	return d
+}
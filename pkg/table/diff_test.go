@@ -0,0 +1,163 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+// makeTable create table of header and rows
+func makeTable(header []string, rows ...[]string) *Table {
+	return &Table{Header: header, Rows: rows}
+}
+
+// mustDiffTables diff two tables, fail on error
+func mustDiffTables(t *testing.T, oldTable *Table, newTable *Table, options DiffOptions) *Diff {
+	t.Helper()
+	d, err := DiffTables(oldTable, newTable, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// getChangedKeys get keys of changed rows of diff
+func getChangedKeys(d *Diff) []string {
+	keys := make([]string, 0)
+	for _, c := range d.Changed {
+		keys = append(keys, c.Key)
+	}
+	return keys
+}
+
+func TestDiffTablesRows(t *testing.T) {
+	oldTable := makeTable([]string{"id", "name", "hp"},
+		[]string{"1", "sword", "10"},
+		[]string{"2", "shield", "20"},
+		[]string{"", " ", ""},
+		[]string{"3", "bow", "30"})
+	newTable := makeTable([]string{"id", "name", "hp"},
+		[]string{"1", "sword", "10"},
+		[]string{"3", "bow", "35"},
+		[]string{"4", "axe"})
+	d := mustDiffTables(t, oldTable, newTable, DiffOptions{})
+	if d.Key != "id" {
+		t.Fatalf("expect the first column as key, got %s", d.Key)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Key != "2" || d.Removed[0].Values["name"] != "shield" {
+		t.Fatalf("unexpected removed rows: %+v", d.Removed)
+	}
+	// missing cells are empty
+	if len(d.Added) != 1 || d.Added[0].Key != "4" || d.Added[0].Values["hp"] != "" {
+		t.Fatalf("unexpected added rows: %+v", d.Added)
+	}
+	expect := []RowChange{{Key: "3", Cells: []CellChange{{Column: "hp", Old: "30", New: "35"}}}}
+	if !reflect.DeepEqual(d.Changed, expect) {
+		t.Fatalf("expect changed rows %+v, got %+v", expect, d.Changed)
+	}
+	if d := mustDiffTables(t, oldTable, oldTable, DiffOptions{Key: "name"}); !d.IsEmpty() {
+		t.Fatalf("expect no difference of the same table, got %+v", d)
+	}
+	if _, err := DiffTables(oldTable, newTable, DiffOptions{Key: "atk"}); err == nil {
+		t.Fatal("expect error of missing key column")
+	}
+}
+
+func TestDiffTablesSchema(t *testing.T) {
+	oldHeader := []string{"id", "name", "hp"}
+	oldRows := [][]string{
+		{"1", "sword", "10"},
+		{"2", "shield", "20"},
+		{"3", "bow", "30"},
+		{"4", "axe", "40"},
+		{"5", "spear", "50"},
+	}
+	// title keeps names of the first rows before renamed, and the last name is changed
+	newHeader := []string{"id", "title", "atk", "hp"}
+	newRows := [][]string{
+		{"1", "sword", "1", "10"},
+		{"2", "shield", "2", "20"},
+		{"3", "bow", "3", "30"},
+		{"4", "axe", "4", "40"},
+		{"5", "lance", "5", "50"},
+	}
+	cases := []struct {
+		name      string
+		renamed   int
+		threshold float64
+		schema    SchemaChange
+		changed   []string
+	}{
+		{"renamed at default threshold", 4, 0,
+			SchemaChange{Added: []string{"atk"}, Removed: []string{}, Renamed: []ColumnRename{{From: "name", To: "title"}}},
+			[]string{"5"}},
+		{"not renamed below default threshold", 3, 0,
+			SchemaChange{Added: []string{"title", "atk"}, Removed: []string{"name"}, Renamed: []ColumnRename{}},
+			[]string{}},
+		{"renamed at custom threshold", 3, 0.6,
+			SchemaChange{Added: []string{"atk"}, Removed: []string{}, Renamed: []ColumnRename{{From: "name", To: "title"}}},
+			[]string{"4", "5"}},
+	}
+	for _, c := range cases {
+		rows := make([][]string, len(newRows))
+		for i, row := range newRows {
+			rows[i] = append([]string{}, row...)
+			if i >= c.renamed {
+				rows[i][1] = "renamed"
+			}
+		}
+		rows[4][1] = "lance"
+		d := mustDiffTables(t, makeTable(oldHeader, oldRows...), makeTable(newHeader, rows...),
+			DiffOptions{RenameThreshold: c.threshold})
+		if !reflect.DeepEqual(d.Schema, c.schema) {
+			t.Fatalf("%s: expect schema %+v, got %+v", c.name, c.schema, d.Schema)
+		}
+		if keys := getChangedKeys(d); !reflect.DeepEqual(keys, c.changed) {
+			t.Fatalf("%s: expect changed rows %v, got %v", c.name, c.changed, keys)
+		}
+		// cells of renamed columns are named as the new table
+		for _, change := range d.Changed {
+			if change.Cells[0].Column != "title" {
+				t.Fatalf("%s: expect change of renamed column, got %+v", c.name, change)
+			}
+		}
+	}
+}
+
+func TestDiffTablesDuplicates(t *testing.T) {
+	// the second a is not mixed up with the real a#2
+	oldTable := makeTable([]string{"id", "hp", "hp", "hp#2"},
+		[]string{"a", "1", "1", "1"},
+		[]string{"a", "2", "2", "2"},
+		[]string{"a#2", "3", "3", "3"})
+	newTable := makeTable([]string{"id", "hp", "hp", "hp#2"},
+		[]string{"a", "1", "1", "1"},
+		[]string{"a", "2", "2", "2"},
+		[]string{"a#2", "3", "4", "5"},
+		[]string{"b", "1", "1", "1"},
+		[]string{"b", "1", "1", "1"})
+	d := mustDiffTables(t, oldTable, newTable, DiffOptions{})
+	expect := []RowChange{{Key: "a#2", Cells: []CellChange{
+		{Column: "hp#3", Old: "3", New: "4"},
+		{Column: "hp#2", Old: "3", New: "5"},
+	}}}
+	if !reflect.DeepEqual(d.Changed, expect) {
+		t.Fatalf("expect changed rows %+v, got %+v", expect, d.Changed)
+	}
+	if !reflect.DeepEqual(d.DuplicateKeys, []string{"a", "b"}) {
+		t.Fatalf("unexpected duplicate keys: %v", d.DuplicateKeys)
+	}
+	if len(d.Added) != 2 || d.Added[0].Key != "b" || d.Added[1].Key != "b#2" {
+		t.Fatalf("unexpected added rows: %+v", d.Added)
+	}
+	if len(d.Removed) != 0 || len(d.Schema.Added) != 0 || len(d.Schema.Removed) != 0 {
+		t.Fatalf("unexpected diff: %+v", d)
+	}
+}
+
+func TestGetColumnNames(t *testing.T) {
+	names := getColumnNames([]string{" id ", "", "#2", "a", "a", "a#2", "a"})
+	expect := []string{"id", "#2", "#2#2", "a", "a#3", "a#2", "a#4"}
+	if !reflect.DeepEqual(names, expect) {
+		t.Fatalf("expect column names %v, got %v", expect, names)
+	}
+}
@@ -0,0 +1,96 @@
+package table
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// utf8BOM byte order mark written by some spreadsheet tools
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Table a parsed table with header row and data rows
+type Table struct {
+	Header []string   `json:"header"`
+	Rows   [][]string `json:"rows"`
+}
+
+// Options options for parsing a table
+type Options struct {
+	// HeaderRow is the index of header row
+	HeaderRow int `json:"headerRow"`
+	// DataRow is the index of first data row, the next row of header if not positive
+	DataRow int `json:"dataRow"`
+	// Delimiter is the field delimiter, detected from file extension if empty
+	Delimiter string `json:"delimiter"`
+	// Sheet is the only sheet to parse of workbook, all sheets if empty
+	Sheet string `json:"sheet"`
+}
+
+// IsDelimitedFile is the file a csv or tsv file by extension
+func IsDelimitedFile(filePath string) bool {
+	ext := strings.ToLower(path.Ext(filePath))
+	return ext == ".csv" || ext == ".tsv"
+}
+
+// getDelimiter get delimiter of file by options or extension
+func getDelimiter(filePath string, options Options) (rune, error) {
+	if options.Delimiter != "" {
+		if options.Delimiter == "\\t" {
+			return '\t', nil
+		}
+		runes := []rune(options.Delimiter)
+		if len(runes) != 1 {
+			return 0, errors.New(fmt.Sprintf("invalid delimiter %q", options.Delimiter))
+		}
+		return runes[0], nil
+	}
+	if strings.ToLower(path.Ext(filePath)) == ".tsv" {
+		return '\t', nil
+	}
+	return ',', nil
+}
+
+// NewTableFromRows create table from raw rows by header and data row options
+func NewTableFromRows(rows [][]string, options Options) (*Table, error) {
+	if options.HeaderRow < 0 || options.HeaderRow >= len(rows) {
+		return nil, errors.New(fmt.Sprintf("header row %d is out of range", options.HeaderRow))
+	}
+	dataRow := options.DataRow
+	if dataRow <= options.HeaderRow {
+		dataRow = options.HeaderRow + 1
+	}
+	t := &Table{Header: rows[options.HeaderRow], Rows: make([][]string, 0)}
+	for i := dataRow; i < len(rows); i++ {
+		t.Rows = append(t.Rows, rows[i])
+	}
+	return t, nil
+}
+
+// ParseDelimited parse csv or tsv content
+func ParseDelimited(filePath string, content []byte, options Options) (*Table, error) {
+	delimiter, err := getDelimiter(filePath, options)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, utf8BOM)))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return NewTableFromRows(rows, options)
+}
+
+// Cell get cell value of row by column index, empty if out of range
+func Cell(row []string, column int) string {
+	if column < 0 || column >= len(row) {
+		return ""
+	}
+	return row[column]
+}
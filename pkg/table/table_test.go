@@ -0,0 +1,39 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDelimited(t *testing.T) {
+	cases := []struct {
+		filePath string
+		content  string
+		options  Options
+		expect   *Table
+	}{
+		{"items.csv", "\xEF\xBB\xBFid,name\n1,\"a,b\"\n2\n", Options{},
+			&Table{Header: []string{"id", "name"}, Rows: [][]string{{"1", "a,b"}, {"2"}}}},
+		{"items.TSV", "id\tname\n1\ta,b\n", Options{},
+			&Table{Header: []string{"id", "name"}, Rows: [][]string{{"1", "a,b"}}}},
+		{"items.txt", "comment\nid;name\ntype;text\n1;a\n", Options{Delimiter: ";", HeaderRow: 1, DataRow: 3},
+			&Table{Header: []string{"id", "name"}, Rows: [][]string{{"1", "a"}}}},
+		{"items.txt", "id\tname\n", Options{Delimiter: "\\t"},
+			&Table{Header: []string{"id", "name"}, Rows: [][]string{}}},
+	}
+	for _, c := range cases {
+		table, err := ParseDelimited(c.filePath, []byte(c.content), c.options)
+		if err != nil {
+			t.Fatalf("%s: %s", c.filePath, err.Error())
+		}
+		if !reflect.DeepEqual(table, c.expect) {
+			t.Fatalf("%s: expect table %+v, got %+v", c.filePath, c.expect, table)
+		}
+	}
+	if _, err := ParseDelimited("items.csv", []byte("id\n"), Options{Delimiter: "||"}); err == nil {
+		t.Fatal("expect error of invalid delimiter")
+	}
+	if _, err := ParseDelimited("items.csv", []byte("id\n"), Options{HeaderRow: 1}); err == nil {
+		t.Fatal("expect error of header row out of range")
+	}
+}
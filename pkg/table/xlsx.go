@@ -0,0 +1,482 @@
+package table
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxXlsxPartSize max uncompressed size of a part in xlsx package
+const maxXlsxPartSize = 256 << 20
+
+// maxXlsxRows max rows of a worksheet, the same as excel
+const maxXlsxRows = 1048576
+
+// maxXlsxColumns max columns of a worksheet, the same as excel
+const maxXlsxColumns = 16384
+
+// CellType type of a spreadsheet cell value
+type CellType string
+
+const (
+	CellEmpty  CellType = "empty"
+	CellString CellType = "string"
+	CellNumber CellType = "number"
+	CellBool   CellType = "bool"
+	CellDate   CellType = "date"
+	CellError  CellType = "error"
+)
+
+// cellValue a typed cell value with its display text
+type cellValue struct {
+	Type  CellType
+	Value interface{}
+	Text  string
+}
+
+// Sheet a parsed sheet, rows are typed values, which are
+// float64 for number, bool for bool, nil for empty and string for the others
+type Sheet struct {
+	Name   string          `json:"name"`
+	Header []string        `json:"header"`
+	Rows   [][]interface{} `json:"rows"`
+	table  *Table
+}
+
+// Table get the text table of sheet
+func (s *Sheet) Table() *Table {
+	return s.table
+}
+
+// Workbook a parsed workbook with its sheets in order
+type Workbook struct {
+	Sheets []*Sheet `json:"sheets"`
+}
+
+// GetSheet get sheet by name, nil if not found
+func (w *Workbook) GetSheet(name string) *Sheet {
+	for _, s := range w.Sheets {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// IsXlsxFile is the file an xlsx workbook by extension
+func IsXlsxFile(filePath string) bool {
+	ext := strings.ToLower(path.Ext(filePath))
+	return ext == ".xlsx" || ext == ".xlsm"
+}
+
+// newSheet create sheet from typed cells by header and data row options
+func newSheet(name string, cells [][]cellValue, options Options) *Sheet {
+	s := &Sheet{Name: name, Header: make([]string, 0), Rows: make([][]interface{}, 0)}
+	s.table = &Table{Header: s.Header, Rows: make([][]string, 0)}
+	if options.HeaderRow < 0 || options.HeaderRow >= len(cells) {
+		return s
+	}
+	for _, cell := range cells[options.HeaderRow] {
+		s.Header = append(s.Header, cell.Text)
+	}
+	s.table.Header = s.Header
+	dataRow := options.DataRow
+	if dataRow <= options.HeaderRow {
+		dataRow = options.HeaderRow + 1
+	}
+	for i := dataRow; i < len(cells); i++ {
+		values := make([]interface{}, len(cells[i]))
+		texts := make([]string, len(cells[i]))
+		for j, cell := range cells[i] {
+			values[j] = cell.Value
+			texts[j] = cell.Text
+		}
+		s.Rows = append(s.Rows, values)
+		s.table.Rows = append(s.table.Rows, texts)
+	}
+	return s
+}
+
+// NewWorkbookFromTable create workbook of a single sheet from text table
+func NewWorkbookFromTable(name string, t *Table) *Workbook {
+	s := &Sheet{Name: name, Header: t.Header, Rows: make([][]interface{}, 0), table: t}
+	for _, row := range t.Rows {
+		values := make([]interface{}, len(row))
+		for i, cell := range row {
+			values[i] = cell
+		}
+		s.Rows = append(s.Rows, values)
+	}
+	return &Workbook{Sheets: []*Sheet{s}}
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Type   string `xml:"Type,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxWorkbook struct {
+	WorkbookPr struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name  string     `xml:"name,attr"`
+		Attrs []xml.Attr `xml:",any,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRichString struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+// text get the plain text of rich string, phonetic runs are excluded
+func (s *xlsxRichString) text() string {
+	var sb strings.Builder
+	sb.WriteString(s.T)
+	for _, r := range s.Runs {
+		sb.WriteString(r.T)
+	}
+	return sb.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxRichString `xml:"si"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R  string          `xml:"r,attr"`
+			T  string          `xml:"t,attr"`
+			S  int             `xml:"s,attr"`
+			V  string          `xml:"v"`
+			Is *xlsxRichString `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// xlsxReader reader of parts in xlsx package
+type xlsxReader struct {
+	files         map[string]*zip.File
+	date1904      bool
+	sharedStrings []string
+	dateStyles    map[int]bool
+}
+
+// readXML decode xml part of package, returns false if part not exists
+func (r *xlsxReader) readXML(name string, v interface{}) (bool, error) {
+	f, ok := r.files[name]
+	if !ok {
+		return false, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return true, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, maxXlsxPartSize+1))
+	if err != nil {
+		return true, err
+	}
+	if len(data) > maxXlsxPartSize {
+		return true, errors.New(fmt.Sprintf("%s exceeds %d bytes", name, maxXlsxPartSize))
+	}
+	if err = xml.Unmarshal(data, v); err != nil {
+		return true, errors.New(fmt.Sprintf("cannot parse %s! %s", name, err.Error()))
+	}
+	return true, nil
+}
+
+// isDateFormatCode is number format code of date or time
+func isDateFormatCode(code string) bool {
+	var sb strings.Builder
+	inQuote, inBracket, escaped := false, false, false
+	for _, ch := range code {
+		switch {
+		case escaped:
+			escaped = false
+		case inQuote:
+			inQuote = ch != '"'
+		case inBracket:
+			inBracket = ch != ']'
+		case ch == '\\' || ch == '_' || ch == '*':
+			escaped = true
+		case ch == '"':
+			inQuote = true
+		case ch == '[':
+			inBracket = true
+		default:
+			sb.WriteRune(ch)
+		}
+	}
+	return strings.ContainsAny(strings.ToLower(sb.String()), "ymdhs")
+}
+
+// isBuiltinDateFormat is built-in number format id of date or time, including cjk locales
+func isBuiltinDateFormat(id int) bool {
+	return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+}
+
+// loadStyles find cell styles of date format
+func (r *xlsxReader) loadStyles() error {
+	var styles xlsxStyles
+	if _, err := r.readXML("xl/styles.xml", &styles); err != nil {
+		return err
+	}
+	dateFormats := make(map[int]bool)
+	for _, numFmt := range styles.NumFmts {
+		dateFormats[numFmt.ID] = isDateFormatCode(numFmt.Code)
+	}
+	r.dateStyles = make(map[int]bool)
+	for i, xf := range styles.CellXfs {
+		isDate, ok := dateFormats[xf.NumFmtID]
+		if !ok {
+			isDate = isBuiltinDateFormat(xf.NumFmtID)
+		}
+		if isDate {
+			r.dateStyles[i] = true
+		}
+	}
+	return nil
+}
+
+// loadSharedStrings load the shared string table
+func (r *xlsxReader) loadSharedStrings() error {
+	var sst xlsxSharedStrings
+	if _, err := r.readXML("xl/sharedStrings.xml", &sst); err != nil {
+		return err
+	}
+	r.sharedStrings = make([]string, len(sst.Items))
+	for i := range sst.Items {
+		r.sharedStrings[i] = sst.Items[i].text()
+	}
+	return nil
+}
+
+// parseCellRef get zero based column index of cell reference like AB12, -1 if invalid
+func parseCellRef(ref string) int {
+	column := 0
+	i := 0
+	for ; i < len(ref); i++ {
+		ch := ref[i]
+		if ch >= 'a' && ch <= 'z' {
+			ch = ch - 'a' + 'A'
+		}
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		column = column*26 + int(ch-'A'+1)
+		if column > maxXlsxColumns {
+			return -1
+		}
+	}
+	if i == 0 {
+		return -1
+	}
+	return column - 1
+}
+
+// formatNumber format number as excel general format with 15 significant digits
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'g', 15, 64)
+}
+
+// toDate convert excel serial date to time
+func (r *xlsxReader) toDate(serial float64) time.Time {
+	var base time.Time
+	if r.date1904 {
+		base = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	} else if serial < 61 {
+		// serials before the fictitious 1900-02-29 of excel
+		base = time.Date(1899, 12, 31, 0, 0, 0, 0, time.UTC)
+	} else {
+		base = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Floor(serial)
+	seconds := math.Round((serial - days) * 86400)
+	return base.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+}
+
+// formatDate format excel serial date as iso date, time or date time
+func (r *xlsxReader) formatDate(serial float64) string {
+	t := r.toDate(serial)
+	if serial == math.Floor(serial) {
+		return t.Format("2006-01-02")
+	}
+	if serial < 1 {
+		return t.Format("15:04:05")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// newCellValue create typed cell value by cell type, style and raw value
+func (r *xlsxReader) newCellValue(t string, style int, raw string, is *xlsxRichString) cellValue {
+	switch t {
+	case "s":
+		i, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || i < 0 || i >= len(r.sharedStrings) {
+			return cellValue{Type: CellEmpty}
+		}
+		return cellValue{Type: CellString, Value: r.sharedStrings[i], Text: r.sharedStrings[i]}
+	case "inlineStr":
+		if is == nil {
+			return cellValue{Type: CellEmpty}
+		}
+		s := is.text()
+		return cellValue{Type: CellString, Value: s, Text: s}
+	case "str":
+		return cellValue{Type: CellString, Value: raw, Text: raw}
+	case "b":
+		b := strings.TrimSpace(raw) == "1"
+		return cellValue{Type: CellBool, Value: b, Text: strings.ToUpper(strconv.FormatBool(b))}
+	case "e":
+		return cellValue{Type: CellError, Value: raw, Text: raw}
+	case "d":
+		return cellValue{Type: CellDate, Value: raw, Text: raw}
+	}
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return cellValue{Type: CellEmpty}
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return cellValue{Type: CellString, Value: raw, Text: raw}
+	}
+	if r.dateStyles[style] && f >= 0 {
+		s := r.formatDate(f)
+		return cellValue{Type: CellDate, Value: s, Text: s}
+	}
+	text := formatNumber(f)
+	f, _ = strconv.ParseFloat(text, 64)
+	return cellValue{Type: CellNumber, Value: f, Text: text}
+}
+
+// readWorksheet read typed cells of worksheet part, rows and columns are aligned by their references
+func (r *xlsxReader) readWorksheet(name string) ([][]cellValue, error) {
+	var ws xlsxWorksheet
+	ok, err := r.readXML(name, &ws)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("sheet part %s is not found", name))
+	}
+	cells := make([][]cellValue, 0)
+	for _, row := range ws.Rows {
+		rowIndex := len(cells)
+		if row.R > 0 {
+			rowIndex = row.R - 1
+		}
+		if rowIndex >= maxXlsxRows {
+			return nil, errors.New(fmt.Sprintf("row %d in %s exceeds max rows %d", rowIndex+1, name, maxXlsxRows))
+		}
+		for len(cells) <= rowIndex {
+			cells = append(cells, make([]cellValue, 0))
+		}
+		for _, c := range row.Cells {
+			column := len(cells[rowIndex])
+			if c.R != "" {
+				if column = parseCellRef(c.R); column < 0 {
+					return nil, errors.New(fmt.Sprintf("invalid cell reference %s in %s", c.R, name))
+				}
+			}
+			for len(cells[rowIndex]) <= column {
+				cells[rowIndex] = append(cells[rowIndex], cellValue{Type: CellEmpty})
+			}
+			cells[rowIndex][column] = r.newCellValue(c.T, c.S, c.V, c.Is)
+		}
+	}
+	return cells, nil
+}
+
+// ParseXlsx parse worksheets of xlsx content, only the sheet in options if specified
+func ParseXlsx(content []byte, options Options) (*Workbook, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid xlsx package! %s", err.Error()))
+	}
+	r := &xlsxReader{files: make(map[string]*zip.File)}
+	for _, f := range zr.File {
+		r.files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+	var wb xlsxWorkbook
+	ok, err := r.readXML("xl/workbook.xml", &wb)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("invalid xlsx package! xl/workbook.xml is not found")
+	}
+	r.date1904 = wb.WorkbookPr.Date1904
+	var rels xlsxRelationships
+	if _, err = r.readXML("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		if strings.HasSuffix(rel.Type, "/worksheet") {
+			if strings.HasPrefix(rel.Target, "/") {
+				targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+			} else {
+				targets[rel.ID] = path.Join("xl", rel.Target)
+			}
+		}
+	}
+	if err = r.loadSharedStrings(); err != nil {
+		return nil, err
+	}
+	if err = r.loadStyles(); err != nil {
+		return nil, err
+	}
+	w := &Workbook{Sheets: make([]*Sheet, 0)}
+	for _, sheet := range wb.Sheets {
+		if options.Sheet != "" && sheet.Name != options.Sheet {
+			continue
+		}
+		var target string
+		for _, attr := range sheet.Attrs {
+			if attr.Name.Local == "id" {
+				target = targets[attr.Value]
+			}
+		}
+		if target == "" {
+			// chart sheets and dialog sheets have no cells
+			continue
+		}
+		cells, err := r.readWorksheet(target)
+		if err != nil {
+			return nil, err
+		}
+		w.Sheets = append(w.Sheets, newSheet(sheet.Name, cells, options))
+	}
+	if options.Sheet != "" && len(w.Sheets) == 0 {
+		return nil, errors.New(fmt.Sprintf("sheet %s is not found", options.Sheet))
+	}
+	return w, nil
+}
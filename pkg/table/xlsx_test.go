@@ -0,0 +1,169 @@
+package table
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testXlsxRels = `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="/xl/worksheets/sheet2.xml"/>
+</Relationships>`
+
+const testXlsxSharedStrings = `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>id</t></si>
+<si><t>name</t></si>
+<si><r><t>Sw</t></r><r><t>ord</t></r><rPh><t>ken</t></rPh></si>
+</sst>`
+
+// testXlsxStyles styles 0 general, 1 built-in date, 2 custom date, 3 custom number with quoted d, 4 built-in date time
+const testXlsxStyles = `<?xml version="1.0" encoding="UTF-8"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts><numFmt numFmtId="164" formatCode="yyyy/mm/dd"/><numFmt numFmtId="165" formatCode="0.00&quot;d&quot;"/></numFmts>
+<cellXfs><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/><xf numFmtId="165"/><xf numFmtId="22"/></cellXfs>
+</styleSheet>`
+
+// testXlsxItems sheet with shared, inline and sparse cells, row 3 and column B of row 4 are missing
+const testXlsxItems = `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="inlineStr"><is><t>date</t></is></c><c r="D1" t="inlineStr"><is><r><t>pri</t></r><r><t>ce</t></r></is></c></row>
+<row r="2"><c r="A2"><v>1</v></c><c r="B2" t="s"><v>2</v></c><c r="C2" s="1"><v>43831</v></c><c r="D2" s="3"><v>1.5</v></c></row>
+<row r="4"><c r="A4"><v>2</v></c><c r="C4" s="4"><v>43831.5</v></c><c r="D4" t="b"><v>1</v></c><c r="F4" t="str"><v>x</v></c></row>
+<row r="5"><c r="A5"><v>3</v></c><c r="B5"><v>0.1</v></c><c r="C5" s="2"><v>59</v></c><c r="D5" s="1"><v>0.25</v></c></row>
+<row r="6"><c r="A6"><v>4</v></c><c r="B6" t="e"><v>#N/A</v></c><c r="C6" s="2"><v>61</v></c></row>
+</sheetData></worksheet>`
+
+// testXlsxUnaligned sheet with references of rows and cells omitted or in lower case
+const testXlsxUnaligned = `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row><c t="inlineStr"><is><t>id</t></is></c><c t="inlineStr"><is><t>name</t></is></c></row>
+<row><c><v>1</v></c><c r="b2" t="s"><v>1</v></c></row>
+</sheetData></worksheet>`
+
+// newXlsx create xlsx package of sheets items and unaligned in the date system, default parts are replaced by parts
+func newXlsx(t *testing.T, date1904 bool, parts map[string]string) []byte {
+	t.Helper()
+	files := map[string]string{
+		"xl/workbook.xml": fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<workbookPr date1904="%t"/><sheets><sheet name="items" sheetId="1" r:id="rId1"/><sheet name="unaligned" sheetId="2" r:id="rId2"/><sheet name="chart" sheetId="3" r:id="rId3"/></sheets>
+</workbook>`, date1904),
+		"xl/_rels/workbook.xml.rels": testXlsxRels,
+		"xl/sharedStrings.xml":       testXlsxSharedStrings,
+		"xl/styles.xml":              testXlsxStyles,
+		"xl/worksheets/sheet1.xml":   testXlsxItems,
+		"xl/worksheets/sheet2.xml":   testXlsxUnaligned,
+	}
+	for name, content := range parts {
+		files[name] = content
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseXlsx(t *testing.T) {
+	w, err := ParseXlsx(newXlsx(t, false, nil), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// chart sheet without worksheet part is skipped
+	if len(w.Sheets) != 2 || w.Sheets[0].Name != "items" || w.Sheets[1].Name != "unaligned" {
+		t.Fatalf("unexpected sheets: %+v", w.Sheets)
+	}
+	items := w.GetSheet("items").Table()
+	expect := &Table{
+		Header: []string{"id", "name", "date", "price"},
+		Rows: [][]string{
+			{"1", "Sword", "2020-01-01", "1.5"},
+			{},
+			{"2", "", "2020-01-01 12:00:00", "TRUE", "", "x"},
+			{"3", "0.1", "1900-02-28", "06:00:00"},
+			{"4", "#N/A", "1900-03-01"},
+		},
+	}
+	if !reflect.DeepEqual(items, expect) {
+		t.Fatalf("expect table %+v, got %+v", expect, items)
+	}
+	rows := w.GetSheet("items").Rows
+	if rows[0][0] != 1.0 || rows[0][1] != "Sword" || rows[2][1] != nil || rows[2][3] != true {
+		t.Fatalf("unexpected typed values: %+v", rows)
+	}
+	unaligned := w.GetSheet("unaligned").Table()
+	expect = &Table{Header: []string{"id", "name"}, Rows: [][]string{{"1", "name"}}}
+	if !reflect.DeepEqual(unaligned, expect) {
+		t.Fatalf("expect table %+v, got %+v", expect, unaligned)
+	}
+}
+
+func TestParseXlsxDate1904(t *testing.T) {
+	w, err := ParseXlsx(newXlsx(t, true, nil), Options{Sheet: "items", HeaderRow: 1, DataRow: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := w.GetSheet("items").Table()
+	expect := &Table{
+		Header: []string{"1", "Sword", "2024-01-02", "1.5"},
+		Rows: [][]string{
+			{"2", "", "2024-01-02 12:00:00", "TRUE", "", "x"},
+			{"3", "0.1", "1904-02-29", "06:00:00"},
+			{"4", "#N/A", "1904-03-02"},
+		},
+	}
+	if len(w.Sheets) != 1 || !reflect.DeepEqual(items, expect) {
+		t.Fatalf("expect only table %+v, got %+v", expect, w.Sheets)
+	}
+}
+
+func TestParseXlsxErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		content []byte
+		options Options
+		err     string
+	}{
+		{"not zip", []byte("id,name\n"), Options{}, "invalid xlsx package"},
+		{"missing workbook", newXlsx(t, false, map[string]string{"xl/workbook.xml": ""}), Options{}, "cannot parse xl/workbook.xml"},
+		{"missing sheet", newXlsx(t, false, nil), Options{Sheet: "monsters"}, "sheet monsters is not found"},
+		{"too many rows", newXlsx(t, false, map[string]string{"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row r="1048577"><c r="A1048577"><v>1</v></c></row></sheetData></worksheet>`}), Options{}, "exceeds max rows"},
+		{"too many columns", newXlsx(t, false, map[string]string{"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row r="1"><c r="XFE1"><v>1</v></c></row></sheetData></worksheet>`}), Options{}, "invalid cell reference XFE1"},
+		{"invalid reference", newXlsx(t, false, map[string]string{"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row r="1"><c r="12"><v>1</v></c></row></sheetData></worksheet>`}), Options{}, "invalid cell reference 12"},
+	}
+	for _, c := range cases {
+		_, err := ParseXlsx(c.content, c.options)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s: expect error of %s, got %v", c.name, c.err, err)
+		}
+	}
+	// the last column is in range
+	content := newXlsx(t, false, map[string]string{"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row r="1"><c r="XFD1"><v>1</v></c></row></sheetData></worksheet>`})
+	w, err := ParseXlsx(content, Options{Sheet: "items"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := w.Sheets[0].Header; len(header) != maxXlsxColumns || header[maxXlsxColumns-1] != "1" {
+		t.Fatalf("expect the last column parsed, got %d columns", len(header))
+	}
+}
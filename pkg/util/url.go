@@ -0,0 +1,16 @@
+package util
+
+import (
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"strings"
+)
+
+// IsLocalURL is url a local path or file url, which is read from the file system of server
+func IsLocalURL(u string) bool {
+	u = strings.TrimSpace(u)
+	if strings.HasPrefix(strings.ToLower(u), "file:") {
+		return true
+	}
+	ep, err := transport.NewEndpoint(u)
+	return err == nil && ep.Protocol == "file"
+}